@@ -1,15 +1,17 @@
 package app
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 
 	"github.com/gorilla/mux"
 	"github.com/jaehanbyun/VM-Disaster-Recovery/common"
 	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/metrics"
 	"github.com/jaehanbyun/VM-Disaster-Recovery/model"
 	"github.com/unrolled/render"
 	"github.com/urfave/negroni"
@@ -17,7 +19,16 @@ import (
 
 type AppHandler struct {
 	http.Handler
-	db model.DBHandler
+	db      model.DBHandler
+	metrics metrics.Source
+	queue   chan string
+	locks   *jobLocks
+
+	// instance identifies this process as the owner of the jobs it runs.
+	instance string
+
+	// approvals serialises decisions on jobs pending approval.
+	approvals sync.Mutex
 }
 
 var (
@@ -31,7 +42,7 @@ func enableCORS(h http.Handler) http.Handler {
 		w.Header().Set("Access-Control-Allow-Origin", "http://*")
 
 		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token ,Authorization")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token ,Authorization, Idempotency-Key")
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
 
@@ -116,25 +127,37 @@ func (a *AppHandler) createInstance(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Println(instanceReq.Volumes)
 
-	err = generateTerraformScript(instanceReq.Name, instanceReq.OS, instanceReq.Ram, instanceReq.Vcpus, instanceReq.Disk, instanceReq.Volumes)
+	job, err := newJob(data.JobKindCreate, "", instanceReq)
 	if err != nil {
-		http.Error(w, "Failed to generate terraform script", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = runTerraformApply()
+	err = a.submitJob(job)
 	if err != nil {
-		http.Error(w, "Failed to run terraform script", http.StatusInternalServerError)
+		http.Error(w, "Failed to queue instance creation", http.StatusInternalServerError)
 		return
 	}
 
-	rd.Text(w, http.StatusOK, "OK")
+	acceptJob(w, job)
 }
 
 func (a *AppHandler) recoverInstance(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
+	var recoverReq data.RecoverRequest
+	err := json.NewDecoder(r.Body).Decode(&recoverReq)
+	if err != nil && err != io.EOF {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	if o := recoverReq.FenceOverride; o != nil && (o.Operator == "" || o.Reason == "") {
+		http.Error(w, "fence_override requires an operator and a reason", http.StatusBadRequest)
+		return
+	}
+
 	targetVM, err := a.db.GetVMInfo(id)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error fetching VM with ID: %s. Error: %v", id, err), http.StatusInternalServerError)
@@ -147,101 +170,267 @@ func (a *AppHandler) recoverInstance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	weights, err := a.db.GetWeight()
-	maxSimilarity := 0.0
-	var mostSimilarVM *data.VMInstance
+	plan, err := a.planRecovery(targetVM, allVMs, recoverReq.Mode)
+	if errors.Is(err, errUnknownMode) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Error planning recovery: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-	for _, vm := range allVMs {
-		if vm.ID == targetVM.ID {
-			continue
-		}
-		similarity := calculateSimilarity(weights, *targetVM, *vm)
-		if similarity > maxSimilarity {
-			maxSimilarity = similarity
-			mostSimilarVM = vm
-		}
+	if r.URL.Query().Get("dry_run") == "true" {
+		rd.JSON(w, http.StatusOK, a.dryRun(plan, recoverReq))
+		return
+	}
+
+	job, err := newJob(data.JobKindRecover, targetVM.ID, recoverReq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	job.Plan = &plan
+
+	err = a.holdForApproval(job, []*data.VMInstance{targetVM}, recoverReq.Operator)
+	if errors.Is(err, errOperatorRequired) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Error checking approval policy: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	if maxSimilarity >= float64(weights.Threshold) {
-		var nonOverlappingVolumes []string
-		for _, volume := range targetVM.Software.Languages {
-			if !contains(mostSimilarVM.Software.Languages, volume) {
-				nonOverlappingVolumes = append(nonOverlappingVolumes, volume.ID)
-			}
+	err = a.queueJob(job, lockKeys([]data.RecoveryPlan{plan}))
+	if err != nil {
+		if !writeLockConflict(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to queue recovery: %v", err), http.StatusInternalServerError)
 		}
-		for _, volume := range targetVM.Software.Databases {
-			if !contains(mostSimilarVM.Software.Databases, volume) {
-				nonOverlappingVolumes = append(nonOverlappingVolumes, volume.ID)
-			}
+		return
+	}
+
+	acceptJob(w, job)
+}
+
+func (a *AppHandler) getWeight(w http.ResponseWriter, r *http.Request) {
+	weight, err := a.db.GetWeight()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting weight: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, weight)
+}
+
+func (a *AppHandler) setWeight(w http.ResponseWriter, r *http.Request) {
+	var weight data.Weight
+
+	err := json.NewDecoder(r.Body).Decode(&weight)
+	if err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	err = a.db.SetWeight(weight)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error setting weight: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, weight)
+}
+
+func (a *AppHandler) getStrategy(w http.ResponseWriter, r *http.Request) {
+	name, err := a.db.GetSetting(strategySetting)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting strategy: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if _, ok := strategies[name]; !ok {
+		name = defaultStrategy
+	}
+
+	rd.JSON(w, http.StatusOK, data.StrategyResponse{Strategy: name, Available: strategyNames()})
+}
+
+func (a *AppHandler) setStrategy(w http.ResponseWriter, r *http.Request) {
+	var strategyReq data.StrategyRequest
+
+	err := json.NewDecoder(r.Body).Decode(&strategyReq)
+	if err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := strategies[strategyReq.Strategy]; !ok {
+		http.Error(w, fmt.Sprintf("unknown strategy: %s", strategyReq.Strategy), http.StatusBadRequest)
+		return
+	}
+
+	err = a.db.SetSetting(strategySetting, strategyReq.Strategy)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error setting strategy: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, data.StrategyResponse{Strategy: strategyReq.Strategy, Available: strategyNames()})
+}
+
+func (a *AppHandler) setInstanceStrategy(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var strategyReq data.StrategyRequest
+
+	err := json.NewDecoder(r.Body).Decode(&strategyReq)
+	if err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	// An empty strategy clears the override and falls back to the global one.
+	if _, ok := strategies[strategyReq.Strategy]; !ok && strategyReq.Strategy != "" {
+		http.Error(w, fmt.Sprintf("unknown strategy: %s", strategyReq.Strategy), http.StatusBadRequest)
+		return
+	}
+
+	err = a.db.SetVMStrategy(id, strategyReq.Strategy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	vm, err := a.db.GetVMInfo(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, vm)
+}
+
+func (a *AppHandler) compareSimilarity(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	targetVM, err := a.db.GetVMInfo(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching VM with ID: %s. Error: %v", id, err), http.StatusInternalServerError)
+		return
+	}
+
+	allVMs, err := a.db.GetVMsInfo()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching all VMs: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	p, err := a.newPlanner(allVMs)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error preparing recovery planner: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	report := data.SimilarityReport{
+		VMID:      targetVM.ID,
+		Threshold: p.weights.Threshold,
+		Active:    a.strategyFor(targetVM).Name(),
+	}
+
+	for _, name := range strategyNames() {
+		candidates := p.rank(strategies[name], targetVM, "")
+		comparison := data.StrategyComparison{
+			Strategy:   name,
+			Action:     "recreate",
+			Candidates: candidates,
 		}
-		for _, volume := range targetVM.Software.Webservers {
-			if !contains(mostSimilarVM.Software.Webservers, volume) {
-				nonOverlappingVolumes = append(nonOverlappingVolumes, volume.ID)
-			}
+		if len(candidates) > 0 && candidates[0].Score >= float64(p.weights.Threshold) {
+			comparison.Selected = &candidates[0]
+			comparison.Action = "consolidate"
 		}
+		report.Comparisons = append(report.Comparisons, comparison)
+	}
 
-		token := common.GetToken()
-
-		for _, volumeID := range nonOverlappingVolumes {
-			body, err := json.Marshal(makeVolumeAttachmentReq(volumeID))
-			if err != nil {
-				http.Error(w, fmt.Sprintf("failed to marshal volume attachment data: %v", err), http.StatusInternalServerError)
-				return
-			}
-			req, err := http.NewRequest("POST", common.BaseOpenstackUrl+"/compute/v2.1/servers/"+mostSimilarVM.ID+"/os-volume_attachments", bytes.NewBuffer(body))
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Error creating new request: %s", err), http.StatusInternalServerError)
-				return
-			}
-			req.Header.Set("X-Auth-Token", token)
-			req.Header.Set("content-type", "application/json")
-			// Openstack Nova Compute API Version 2.60 Include
-			req.Header.Set("Openstack-API-Version", "compute 2.60")
-
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("error sending volume attachment request: %s", err), http.StatusInternalServerError)
-				return
-			}
-			resp.Body.Close()
-			if resp.StatusCode != http.StatusOK {
-				http.Error(w, "failed to attach volume", http.StatusInternalServerError)
-				return
-			}
-		}
-	} else {
-		newVMName := targetVM.Name + "-new"
-		var volumes []string
+	rd.JSON(w, http.StatusOK, report)
+}
+
+func (a *AppHandler) getFailureDomain(w http.ResponseWriter, r *http.Request) {
+	domain, err := a.db.GetSetting(failureDomainSetting)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting failure domain: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if domain != domainZone {
+		domain = domainHost
+	}
+
+	rd.JSON(w, http.StatusOK, data.FailureDomainRequest{FailureDomain: domain})
+}
+
+func (a *AppHandler) setFailureDomain(w http.ResponseWriter, r *http.Request) {
+	var domainReq data.FailureDomainRequest
+
+	err := json.NewDecoder(r.Body).Decode(&domainReq)
+	if err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	if domainReq.FailureDomain != domainHost && domainReq.FailureDomain != domainZone {
+		http.Error(w, fmt.Sprintf("failure domain must be %q or %q", domainHost, domainZone), http.StatusBadRequest)
+		return
+	}
+
+	err = a.db.SetSetting(failureDomainSetting, domainReq.FailureDomain)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error setting failure domain: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-		for _, langVolume := range targetVM.Software.Languages {
-			volumes = append(volumes, langVolume.ID)
+	rd.JSON(w, http.StatusOK, domainReq)
+}
+
+func findVM(vms []*data.VMInstance, id string) *data.VMInstance {
+	for _, vm := range vms {
+		if vm.ID == id {
+			return vm
 		}
-		for _, dbVolume := range targetVM.Software.Databases {
-			volumes = append(volumes, dbVolume.ID)
+	}
+	return nil
+}
+
+// nonOverlappingVolumes lists the volumes of source that target does not already have.
+func nonOverlappingVolumes(source, target *data.VMInstance) []string {
+	var volumes []string
+	for _, volume := range source.Software.Languages {
+		if !contains(target.Software.Languages, volume) {
+			volumes = append(volumes, volume.ID)
 		}
-		for _, wsVolume := range targetVM.Software.Webservers {
-			volumes = append(volumes, wsVolume.ID)
+	}
+	for _, volume := range source.Software.Databases {
+		if !contains(target.Software.Databases, volume) {
+			volumes = append(volumes, volume.ID)
 		}
-
-		err := recreateTerraformScript(newVMName, targetVM.OS, targetVM.FlavorID, volumes)
-		if err != nil {
-			rd.Text(w, http.StatusInternalServerError, fmt.Sprintf("Failed to recreate VM: %s", err))
-			return
+	}
+	for _, volume := range source.Software.Webservers {
+		if !contains(target.Software.Webservers, volume) {
+			volumes = append(volumes, volume.ID)
 		}
 	}
-
-	rd.Text(w, http.StatusOK, fmt.Sprintf("Created a new VM with base of VM with ID %s", targetVM.ID))
+	return volumes
 }
 
-func makeVolumeAttachmentReq(volumeId string) data.VolumeAttachmentsRequest {
-	volumeAttachmentReq := data.VolumeAttachmentsRequest{
-		VolumeAttachment: data.VolumeAttachment{
-			VolumeID: volumeId,
-		},
+func allVolumes(vm *data.VMInstance) []string {
+	var volumes []string
+	for _, volume := range vm.Software.Languages {
+		volumes = append(volumes, volume.ID)
 	}
-
-	return volumeAttachmentReq
+	for _, volume := range vm.Software.Databases {
+		volumes = append(volumes, volume.ID)
+	}
+	for _, volume := range vm.Software.Webservers {
+		volumes = append(volumes, volume.ID)
+	}
+	return volumes
 }
 
 func contains(volumes []data.Volume, vol data.Volume) bool {
@@ -294,15 +483,50 @@ func MakeHandler() *AppHandler {
 	neg.UseHandler(r)
 
 	a := &AppHandler{
-		Handler: neg,
-		db:      model.NewDBHandler(),
+		Handler:  neg,
+		db:       model.NewDBHandler(),
+		locks:    newJobLocks(),
+		metrics:  metrics.NewSource(common.PrometheusUrl, common.MetricsFile),
+		instance: newInstanceID(),
 	}
 
 	r.HandleFunc("/volumes", a.getVolumes).Methods("GET")
 	r.HandleFunc("/instance", a.getInstances).Methods("GET")
-	r.HandleFunc("/instance", a.createInstance).Methods("POST")
+	r.HandleFunc("/instance", a.withIdempotency(a.createInstance)).Methods("POST")
 	r.HandleFunc("/instance/{id}", a.getInstanceByID).Methods("GET")
-	r.HandleFunc("/instance/{id}/recover", a.recoverInstance).Methods("POST")
+	r.HandleFunc("/instance/{id}/recover", a.withIdempotency(a.recoverInstance)).Methods("POST")
+	r.HandleFunc("/instance/{id}/failback", a.withIdempotency(a.failbackInstance)).Methods("POST")
+	r.HandleFunc("/instance/{id}/strategy", a.setInstanceStrategy).Methods("PUT")
+	r.HandleFunc("/instance/{id}/similarity", a.compareSimilarity).Methods("GET")
+	r.HandleFunc("/instance/{id}/validation", a.getValidation).Methods("GET")
+	r.HandleFunc("/instance/{id}/lineage", a.getLineage).Methods("GET")
+	r.HandleFunc("/instance/{id}/health", a.getHealth).Methods("GET")
+	r.HandleFunc("/instance/{id}/rto", a.setTargetRTO).Methods("PUT")
+	r.HandleFunc("/instance/{id}/validation", a.setValidation).Methods("PUT")
+	r.HandleFunc("/recover", a.withIdempotency(a.recoverBulk)).Methods("POST")
+	r.HandleFunc("/plans", a.getPlans).Methods("GET")
+	r.HandleFunc("/plans/{name}", a.getPlan).Methods("GET")
+	r.HandleFunc("/plans/{name}", a.setPlan).Methods("PUT")
+	r.HandleFunc("/plans/{name}", a.deletePlan).Methods("DELETE")
+	r.HandleFunc("/plans/{name}/run", a.withIdempotency(a.runPlan)).Methods("POST")
+	r.HandleFunc("/jobs", a.getJobs).Methods("GET")
+	r.HandleFunc("/jobs/{id}", a.getJob).Methods("GET")
+	r.HandleFunc("/jobs/{id}/approve", a.approveJob).Methods("POST")
+	r.HandleFunc("/jobs/{id}/reject", a.rejectJob).Methods("POST")
+	r.HandleFunc("/jobs/{id}/mount-script", a.getMountScript).Methods("GET")
+	r.HandleFunc("/reports/rto", a.getRTOReport).Methods("GET")
+	r.HandleFunc("/health", a.getHealthSummary).Methods("GET")
+	r.HandleFunc("/approval-policy", a.getApprovalPolicy).Methods("GET")
+	r.HandleFunc("/approval-policy", a.setApprovalPolicy).Methods("PUT")
+	r.HandleFunc("/simulate", a.simulateFailure).Methods("POST")
+	r.HandleFunc("/weight", a.getWeight).Methods("GET")
+	r.HandleFunc("/weight", a.setWeight).Methods("PUT")
+	r.HandleFunc("/strategy", a.getStrategy).Methods("GET")
+	r.HandleFunc("/strategy", a.setStrategy).Methods("PUT")
+	r.HandleFunc("/failure-domain", a.getFailureDomain).Methods("GET")
+	r.HandleFunc("/failure-domain", a.setFailureDomain).Methods("PUT")
+	r.HandleFunc("/recovery-mode", a.getRecoveryMode).Methods("GET")
+	r.HandleFunc("/recovery-mode", a.setRecoveryMode).Methods("PUT")
 
 	err := a.db.Init()
 
@@ -310,5 +534,7 @@ func MakeHandler() *AppHandler {
 		panic(err)
 	}
 
+	a.startWorkers()
+
 	return a
 }
@@ -0,0 +1,406 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/model"
+)
+
+const (
+	approvalPolicySetting = "approval_policy"
+	defaultApprovalExpiry = 24 * time.Hour
+	approvalSweepInterval = time.Minute
+)
+
+// errOperatorRequired is returned for a request the approval policy covers
+// that does not say who made it, as it could then be approved by anyone.
+var errOperatorRequired = errors.New("an operator is required for a request that needs approval")
+
+func (a *AppHandler) approvalPolicy() (data.ApprovalPolicy, error) {
+	policy := data.ApprovalPolicy{VMIDs: []string{}, Categories: []string{}}
+
+	value, err := a.db.GetSetting(approvalPolicySetting)
+	if err != nil || value == "" {
+		return policy, err
+	}
+
+	err = json.Unmarshal([]byte(value), &policy)
+	if err != nil {
+		return policy, fmt.Errorf("error unmarshaling approval policy: %v", err)
+	}
+	return policy, nil
+}
+
+func (a *AppHandler) getApprovalPolicy(w http.ResponseWriter, r *http.Request) {
+	policy, err := a.approvalPolicy()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting approval policy: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, policy)
+}
+
+func (a *AppHandler) setApprovalPolicy(w http.ResponseWriter, r *http.Request) {
+	var policy data.ApprovalPolicy
+
+	err := json.NewDecoder(r.Body).Decode(&policy)
+	if err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	for _, category := range policy.Categories {
+		if !toSet(categories)[category] {
+			http.Error(w, fmt.Sprintf("category must be one of %s", strings.Join(categories, ", ")), http.StatusBadRequest)
+			return
+		}
+	}
+	if policy.ExpirySeconds < 0 {
+		http.Error(w, "expiry_seconds must not be negative", http.StatusBadRequest)
+		return
+	}
+	if policy.VMIDs == nil {
+		policy.VMIDs = []string{}
+	}
+	if policy.Categories == nil {
+		policy.Categories = []string{}
+	}
+
+	value, _ := json.Marshal(policy)
+	err = a.db.SetSetting(approvalPolicySetting, string(value))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error setting approval policy: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, policy)
+}
+
+// approvalReason explains why the policy requires approval to move the
+// volumes of vms, or returns "" when they can be moved right away.
+func approvalReason(policy data.ApprovalPolicy, vms []*data.VMInstance) string {
+	var reasons []string
+	for _, vm := range vms {
+		if toSet(policy.VMIDs)[vm.ID] {
+			reasons = append(reasons, fmt.Sprintf("%s requires approval", vm.Name))
+			continue
+		}
+		for _, category := range policy.Categories {
+			if len(allVolumes(categoryView(vm, category))) > 0 {
+				reasons = append(reasons, fmt.Sprintf("%s has %s volumes", vm.Name, category))
+				break
+			}
+		}
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// holdForApproval leaves job pending approval when the policy covers any of
+// vms. Such a job is stored but neither locked nor queued until approved,
+// and must name the operator who requested it.
+func (a *AppHandler) holdForApproval(job *data.Job, vms []*data.VMInstance, requestedBy string) error {
+	policy, err := a.approvalPolicy()
+	if err != nil {
+		return err
+	}
+
+	reason := approvalReason(policy, vms)
+	if reason == "" {
+		return nil
+	}
+	if strings.TrimSpace(requestedBy) == "" {
+		return errOperatorRequired
+	}
+
+	expiry := defaultApprovalExpiry
+	if policy.ExpirySeconds > 0 {
+		expiry = time.Duration(policy.ExpirySeconds) * time.Second
+	}
+
+	job.Status = data.JobPendingApproval
+	job.Approval = &data.Approval{
+		Required:    reason,
+		RequestedBy: requestedBy,
+		ExpiresAt:   time.Now().UTC().Add(expiry),
+	}
+	return nil
+}
+
+// queueJob locks keys for job and queues it, or only stores it when it is
+// pending approval. A *model.LockedError is returned as is.
+func (a *AppHandler) queueJob(job *data.Job, keys []string) error {
+	if job.Status == data.JobPendingApproval {
+		return a.db.CreateJob(*job)
+	}
+
+	err := a.lockJob(job, keys)
+	if err != nil {
+		return err
+	}
+
+	err = a.submitJob(job)
+	if err != nil {
+		a.unlockJob(job.ID)
+		return err
+	}
+	return nil
+}
+
+func (a *AppHandler) approveJob(w http.ResponseWriter, r *http.Request) {
+	a.decideJob(w, r, data.ApprovalApproved)
+}
+
+func (a *AppHandler) rejectJob(w http.ResponseWriter, r *http.Request) {
+	a.decideJob(w, r, data.ApprovalRejected)
+}
+
+// decideJob records an operator's decision on a job pending approval. An
+// approved job is planned again and queued; the operator who requested it
+// cannot approve it.
+func (a *AppHandler) decideJob(w http.ResponseWriter, r *http.Request, decision string) {
+	var decisionReq data.ApprovalDecision
+
+	err := json.NewDecoder(r.Body).Decode(&decisionReq)
+	if err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(decisionReq.Operator) == "" || strings.TrimSpace(decisionReq.Reason) == "" {
+		http.Error(w, "an operator and a reason are required", http.StatusBadRequest)
+		return
+	}
+
+	a.approvals.Lock()
+	defer a.approvals.Unlock()
+
+	job, err := a.db.GetJob(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if job.Status != data.JobPendingApproval || job.Approval == nil {
+		http.Error(w, fmt.Sprintf("job is %s, not pending approval", job.Status), http.StatusConflict)
+		return
+	}
+	if time.Now().After(job.Approval.ExpiresAt) {
+		err = a.closeApproval(job, data.JobExpired, "approval expired")
+		if err != nil && !errors.Is(err, model.ErrJobStatusChanged) {
+			fmt.Printf("Error expiring job %s: %s\n", job.ID, err)
+		}
+		http.Error(w, "approval expired", http.StatusConflict)
+		return
+	}
+	if decision == data.ApprovalApproved && decisionReq.Operator == job.Approval.RequestedBy {
+		http.Error(w, "a job must be approved by someone other than who requested it", http.StatusForbidden)
+		return
+	}
+	if decision == data.ApprovalApproved && job.Approval.RequestedBy == "" {
+		http.Error(w, "the job does not name who requested it and can only be rejected", http.StatusForbidden)
+		return
+	}
+
+	now := time.Now().UTC()
+	job.Approval.Decision = decision
+	job.Approval.DecidedBy = decisionReq.Operator
+	job.Approval.Reason = decisionReq.Reason
+	job.Approval.DecidedAt = &now
+
+	if decision == data.ApprovalRejected {
+		err = a.closeApproval(job, data.JobRejected, "rejected by "+decisionReq.Operator)
+		if !writeDecisionError(w, job, err) {
+			rd.JSON(w, http.StatusOK, job)
+		}
+		return
+	}
+
+	// The inventory may have changed while the job waited, so it runs a
+	// plan made against the inventory as it is now.
+	err = a.replanJob(job)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error re-planning job: %v", err), http.StatusConflict)
+		return
+	}
+
+	job.Status = data.JobQueued
+	err = a.db.UpdateJobIf(*job, data.JobPendingApproval)
+	if writeDecisionError(w, job, err) {
+		return
+	}
+	a.queue <- job.ID
+
+	rd.JSON(w, http.StatusOK, job)
+}
+
+// writeDecisionError writes the error of recording a decision on job, if
+// any, and reports whether it did.
+func writeDecisionError(w http.ResponseWriter, job *data.Job, err error) bool {
+	if errors.Is(err, model.ErrJobStatusChanged) {
+		http.Error(w, fmt.Sprintf("job %s was decided by another request", job.ID), http.StatusConflict)
+		return true
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("error deciding job: %v", err), http.StatusInternalServerError)
+		return true
+	}
+	return false
+}
+
+// replanJob plans a recovery job and the per-VM children of a bulk or plan
+// job again. Children are planned against one shared planner, as when the
+// job was requested. A failback follows the lineage and is not re-planned.
+func (a *AppHandler) replanJob(job *data.Job) error {
+	if job.Kind != data.JobKindRecover && !hasChildren(job.Kind) {
+		return nil
+	}
+
+	var recoverReq data.RecoverRequest
+	var failedHosts []string
+	switch job.Kind {
+	case data.JobKindBulk:
+		var bulkReq data.BulkRecoverRequest
+		err := json.Unmarshal(job.Request, &bulkReq)
+		if err != nil {
+			return fmt.Errorf("error unmarshaling bulk request: %v", err)
+		}
+		recoverReq = bulkReq.RecoverRequest
+		failedHosts = bulkFailedHosts(bulkReq)
+	case data.JobKindPlan:
+		var planRun data.PlanRun
+		err := json.Unmarshal(job.Request, &planRun)
+		if err != nil {
+			return fmt.Errorf("error unmarshaling plan run: %v", err)
+		}
+		recoverReq = planRun.RecoverRequest
+	default:
+		err := json.Unmarshal(job.Request, &recoverReq)
+		if err != nil {
+			return fmt.Errorf("error unmarshaling recover request: %v", err)
+		}
+	}
+
+	allVMs, err := a.db.GetVMsInfo()
+	if err != nil {
+		return err
+	}
+
+	if job.Kind == data.JobKindRecover {
+		source := findVM(allVMs, job.VMID)
+		if source == nil {
+			return fmt.Errorf("VM %s is no longer in the inventory", job.VMID)
+		}
+		plan, err := a.planRecovery(source, allVMs, recoverReq.Mode)
+		if err != nil {
+			return err
+		}
+		job.Plan = &plan
+		return nil
+	}
+
+	mode, err := a.recoveryMode(recoverReq.Mode)
+	if err != nil {
+		return err
+	}
+	children, err := a.db.GetChildJobs(job.ID)
+	if err != nil {
+		return err
+	}
+	// Children that could not be planned when the job was requested stay
+	// failed; the others are planned again and may fail now.
+	var queued []*data.Job
+	var failedVMs []*data.VMInstance
+	for _, child := range children {
+		if child.Status != data.JobQueued {
+			continue
+		}
+		vm := findVM(allVMs, child.VMID)
+		if vm == nil {
+			return fmt.Errorf("VM %s is no longer in the inventory", child.VMID)
+		}
+		queued = append(queued, child)
+		failedVMs = append(failedVMs, vm)
+	}
+
+	p, err := a.newFailurePlanner(allVMs, failedVMs, failedHosts)
+	if err != nil {
+		return fmt.Errorf("error preparing recovery planner: %v", err)
+	}
+	plans, failures := p.planAll(failedVMs, mode)
+	if len(plans) == 0 {
+		return fmt.Errorf("%s", joinFailures(failedVMs, failures))
+	}
+	for _, child := range queued {
+		if plan, ok := plans[child.VMID]; ok {
+			child.Plan = &plan
+		} else {
+			child.Plan = nil
+			failUnplanned(child, failures[child.VMID])
+		}
+		err := a.db.UpdateJob(*child)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeApproval finishes a job that will never run, together with its
+// per-VM children. It fails with model.ErrJobStatusChanged when the job
+// is no longer pending approval, leaving the children alone.
+func (a *AppHandler) closeApproval(job *data.Job, status string, reason string) error {
+	now := time.Now().UTC()
+	job.Status = status
+	job.Error = reason
+	job.FinishedAt = &now
+	err := a.db.UpdateJobIf(*job, data.JobPendingApproval)
+	if err != nil {
+		return err
+	}
+
+	if !hasChildren(job.Kind) {
+		return nil
+	}
+	children, err := a.db.GetChildJobs(job.ID)
+	if err != nil {
+		fmt.Printf("Error loading child jobs of %s: %s\n", job.ID, err)
+	}
+	for _, child := range children {
+		// A child that could not be planned keeps its own failure.
+		if child.Status != data.JobQueued {
+			continue
+		}
+		child.Status = status
+		child.Error = reason
+		child.FinishedAt = &now
+		if err := a.db.UpdateJob(*child); err != nil {
+			fmt.Printf("Error closing job %s: %s\n", child.ID, err)
+		}
+	}
+	return nil
+}
+
+// expireApprovals periodically closes jobs whose approval window passed.
+func (a *AppHandler) expireApprovals() {
+	for range time.Tick(approvalSweepInterval) {
+		a.approvals.Lock()
+		pending, err := a.db.GetJobsByStatus(data.JobPendingApproval)
+		if err != nil {
+			fmt.Printf("Error loading jobs pending approval: %s\n", err)
+		}
+		for _, job := range pending {
+			if job.Approval != nil && time.Now().After(job.Approval.ExpiresAt) {
+				err := a.closeApproval(job, data.JobExpired, "approval expired")
+				if err != nil && !errors.Is(err, model.ErrJobStatusChanged) {
+					fmt.Printf("Error expiring job %s: %s\n", job.ID, err)
+				}
+			}
+		}
+		a.approvals.Unlock()
+	}
+}
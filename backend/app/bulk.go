@@ -0,0 +1,286 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/common"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+const (
+	defaultBulkParallelism = 4
+	maxBulkParallelism     = 16
+)
+
+// recoverBulk plans the recovery of every selected VM against one shared
+// planner, so no two of them are given the same spare capacity, and queues
+// a bulk job that runs the per-VM recoveries with bounded parallelism. A VM
+// that cannot be planned gets a failed child job and the others still run.
+func (a *AppHandler) recoverBulk(w http.ResponseWriter, r *http.Request) {
+	var bulkReq data.BulkRecoverRequest
+
+	err := json.NewDecoder(r.Body).Decode(&bulkReq)
+	if err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(bulkReq.VMIDs) == 0 && bulkReq.Host == "" && bulkReq.AvailabilityZone == "" {
+		http.Error(w, "one of vm_ids, host or availability_zone is required", http.StatusBadRequest)
+		return
+	}
+	if o := bulkReq.FenceOverride; o != nil && (o.Operator == "" || o.Reason == "") {
+		http.Error(w, "fence_override requires an operator and a reason", http.StatusBadRequest)
+		return
+	}
+
+	mode, err := a.recoveryMode(bulkReq.Mode)
+	if errors.Is(err, errUnknownMode) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("error getting recovery mode: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	allVMs, err := a.db.GetVMsInfo()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching all VMs: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	selection := data.SimulationRequest{VMIDs: bulkReq.VMIDs}
+	if bulkReq.Host != "" {
+		selection.Hosts = []string{bulkReq.Host}
+	}
+	if bulkReq.AvailabilityZone != "" {
+		selection.AvailabilityZones = []string{bulkReq.AvailabilityZone}
+	}
+
+	failedVMs := matchFailedVMs(allVMs, selection)
+	if len(failedVMs) == 0 {
+		http.Error(w, "no VMs match the request", http.StatusNotFound)
+		return
+	}
+
+	p, err := a.newFailurePlanner(allVMs, failedVMs, bulkFailedHosts(bulkReq))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error preparing recovery planner: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	parent, err := newJob(data.JobKindBulk, "", bulkReq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	plans, failures := p.planAll(failedVMs, mode)
+	if len(plans) == 0 {
+		http.Error(w, joinFailures(failedVMs, failures), http.StatusConflict)
+		return
+	}
+
+	var children []*data.Job
+	var planned []data.RecoveryPlan
+	for _, vm := range failedVMs {
+		child, err := newJob(data.JobKindRecover, vm.ID, bulkReq.RecoverRequest)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		child.ParentID = parent.ID
+		if plan, ok := plans[vm.ID]; ok {
+			child.Plan = &plan
+			planned = append(planned, plan)
+		} else {
+			failUnplanned(child, failures[vm.ID])
+		}
+
+		children = append(children, child)
+	}
+
+	err = a.holdForApproval(parent, failedVMs, bulkReq.Operator)
+	if errors.Is(err, errOperatorRequired) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Error checking approval policy: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	err = a.submitWithChildren(parent, children, planned)
+	if err != nil {
+		if !writeLockConflict(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to queue recovery: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	acceptJob(w, parent)
+}
+
+// bulkFailedHosts lists the hosts a bulk request declares failed: its host
+// and every compute host of its availability zone, including those that
+// run no VM of the inventory.
+func bulkFailedHosts(bulkReq data.BulkRecoverRequest) []string {
+	var hosts []string
+	if bulkReq.Host != "" {
+		hosts = append(hosts, bulkReq.Host)
+	}
+	if bulkReq.AvailabilityZone != "" {
+		zoneHosts, err := common.GetZoneHosts(common.GetToken(), bulkReq.AvailabilityZone)
+		if err != nil {
+			fmt.Printf("Error fetching hosts of %s, only hosts of its VMs are excluded: %s\n", bulkReq.AvailabilityZone, err)
+		}
+		hosts = append(hosts, zoneHosts...)
+	}
+	return hosts
+}
+
+// failUnplanned records on child that its VM could not be planned, so the
+// parent reports it without running it.
+func failUnplanned(child *data.Job, err error) {
+	now := time.Now().UTC()
+	child.Status = data.JobFailed
+	child.Error = fmt.Sprintf("error planning recovery: %v", err)
+	child.FinishedAt = &now
+}
+
+// joinFailures lists the planning errors of vms in their order.
+func joinFailures(vms []*data.VMInstance, failures map[string]error) string {
+	var messages []string
+	for _, vm := range vms {
+		if err, ok := failures[vm.ID]; ok {
+			messages = append(messages, err.Error())
+		}
+	}
+	return strings.Join(messages, "; ")
+}
+
+// runBulkJob runs the per-VM jobs of a bulk job, at most parallelism at a
+// time, and fails if any of them did not succeed.
+func (a *AppHandler) runBulkJob(run *jobRun) error {
+	var bulkReq data.BulkRecoverRequest
+	err := json.Unmarshal(run.job.Request, &bulkReq)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling bulk request: %v", err)
+	}
+
+	parallelism := boundedParallelism(bulkReq.Parallelism)
+
+	children, err := a.db.GetChildJobs(run.job.ID)
+	if err != nil {
+		return fmt.Errorf("error loading child jobs: %v", err)
+	}
+
+	failed := a.runChildren(run, children, parallelism, nil)
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d recoveries did not succeed", len(failed), len(children))
+	}
+	return nil
+}
+
+// submitWithChildren stores the per-VM children of parent and queues
+// parent, which runs them, under the locks of their plans. A parent pending
+// approval is stored without locking or queueing it. A *model.LockedError
+// is returned as is.
+func (a *AppHandler) submitWithChildren(parent *data.Job, children []*data.Job, plans []data.RecoveryPlan) error {
+	pending := parent.Status == data.JobPendingApproval
+	if !pending {
+		err := a.lockJob(parent, lockKeys(plans))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, child := range children {
+		err := a.db.CreateJob(*child)
+		if err != nil {
+			a.unlockJob(parent.ID)
+			return err
+		}
+	}
+
+	if pending {
+		return a.db.CreateJob(*parent)
+	}
+
+	err := a.submitJob(parent)
+	if err != nil {
+		a.unlockJob(parent.ID)
+		return err
+	}
+	return nil
+}
+
+// runChildren runs child jobs concurrently, at most parallelism at a time,
+// each as a step of run. When after is set it runs inside the step once a
+// child has succeeded. It returns the IDs of the children that failed.
+func (a *AppHandler) runChildren(run *jobRun, children []*data.Job, parallelism int, after func(*data.Job) error) map[string]bool {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := make(map[string]bool)
+	sem := make(chan struct{}, parallelism)
+
+	for _, child := range children {
+		child := child
+		run.addTarget(child.VMID)
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := run.step(fmt.Sprintf("recover %s (job %s)", child.VMID, child.ID), func() error {
+				err := a.runChild(child.ID)
+				if err != nil || after == nil {
+					return err
+				}
+				return after(child)
+			})
+			if err != nil {
+				mu.Lock()
+				failed[child.ID] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return failed
+}
+
+// boundedParallelism applies the default to an unset parallelism and caps it.
+func boundedParallelism(n int) int {
+	if n <= 0 {
+		return defaultBulkParallelism
+	}
+	if n > maxBulkParallelism {
+		return maxBulkParallelism
+	}
+	return n
+}
+
+// runChild runs a child job to completion and reports its outcome.
+func (a *AppHandler) runChild(id string) error {
+	a.runJob(id)
+
+	child, err := a.db.GetJob(id)
+	if err != nil {
+		return err
+	}
+	if child.Status != data.JobSucceeded {
+		if child.Error != "" {
+			return fmt.Errorf("%s: %s", child.Status, child.Error)
+		}
+		return fmt.Errorf("%s", child.Status)
+	}
+	return nil
+}
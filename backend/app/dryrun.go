@@ -0,0 +1,107 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/common"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+// dryRun lists the calls executing plan would make, step by step, and for
+// a recreation checks the flavor against the project's compute quota.
+func (a *AppHandler) dryRun(plan data.RecoveryPlan, recoverReq data.RecoverRequest) data.DryRunResult {
+	token := common.GetToken()
+
+	actions := append([]action{fenceAction(nil, token, plan, recoverReq)}, a.planActions(nil, plan)...)
+	actions = append(actions, a.inventoryAction(nil, token, plan))
+	result := data.DryRunResult{Plan: &plan, Steps: previewActions(actions)}
+
+	if plan.Recreate != nil {
+		quota, err := checkQuota(token, plan.Recreate.FlavorID)
+		if err != nil {
+			result.Error = fmt.Sprintf("error checking quota: %v", err)
+		} else {
+			result.Quota = &quota
+		}
+	}
+
+	return result
+}
+
+func previewActions(actions []action) []data.DryRunStep {
+	steps := []data.DryRunStep{}
+	for _, act := range actions {
+		step := data.DryRunStep{Name: act.name, Calls: []data.APICall{}}
+		if act.preview != nil {
+			if calls := act.preview(); calls != nil {
+				step.Calls = calls
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// checkQuota reports whether the project has room for one more server of
+// the given flavor.
+func checkQuota(token string, flavorID string) (data.QuotaCheck, error) {
+	flavor, err := common.GetFlavor(token, flavorID)
+	if err != nil {
+		return data.QuotaCheck{}, err
+	}
+	limits, err := common.GetLimits(token)
+	if err != nil {
+		return data.QuotaCheck{}, err
+	}
+
+	check := data.QuotaCheck{
+		Requested: data.ComputeAmount{Cores: flavor.Vcpus, RAM: flavor.Ram, Instances: 1},
+		Available: data.ComputeAmount{
+			Cores:     remaining(limits.MaxTotalCores, limits.TotalCoresUsed),
+			RAM:       remaining(limits.MaxTotalRAMSize, limits.TotalRAMUsed),
+			Instances: remaining(limits.MaxTotalInstances, limits.TotalInstancesUsed),
+		},
+		Sufficient: true,
+	}
+
+	if !fits(check.Available.Cores, check.Requested.Cores) {
+		check.Sufficient = false
+		check.Detail = fmt.Sprintf("needs %d cores, %d left", check.Requested.Cores, check.Available.Cores)
+	} else if !fits(check.Available.RAM, check.Requested.RAM) {
+		check.Sufficient = false
+		check.Detail = fmt.Sprintf("needs %d MB of RAM, %d MB left", check.Requested.RAM, check.Available.RAM)
+	} else if !fits(check.Available.Instances, check.Requested.Instances) {
+		check.Sufficient = false
+		check.Detail = "instance quota exhausted"
+	}
+
+	return check, nil
+}
+
+// remaining returns what is left of a limit, or -1 when it is unlimited.
+func remaining(limit int, used int) int {
+	if limit < 0 {
+		return -1
+	}
+	return limit - used
+}
+
+func fits(available int, requested int) bool {
+	return available < 0 || requested <= available
+}
+
+func serverActionCall(serverID string, body interface{}) data.APICall {
+	return data.APICall{Method: "POST", URL: common.BaseOpenstackUrl + "/compute/v2.1/servers/" + serverID + "/action", Body: body}
+}
+
+func attachCall(serverID string, volumeID string, device string) data.APICall {
+	call := data.APICall{
+		Method: "POST",
+		URL:    common.BaseOpenstackUrl + "/compute/v2.1/servers/" + serverID + "/os-volume_attachments",
+		Body:   data.VolumeAttachmentsRequest{VolumeAttachment: data.VolumeAttachment{VolumeID: volumeID, Device: device}},
+	}
+	if device != "" {
+		call.Note = "without the device if it is taken on the server"
+	}
+	return call
+}
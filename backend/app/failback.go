@@ -0,0 +1,379 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/common"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+// failbackInstance returns the volumes of a repaired VM from the VMs that
+// took them over, following the lineage recorded when it was recovered.
+func (a *AppHandler) failbackInstance(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var failbackReq data.FailbackRequest
+	err := json.NewDecoder(r.Body).Decode(&failbackReq)
+	if err != nil && err != io.EOF {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	source, err := a.db.GetVMInfo(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching VM with ID: %s. Error: %v", id, err), http.StatusNotFound)
+		return
+	}
+	if source.Status != data.VMRecovered {
+		http.Error(w, fmt.Sprintf("VM %s has not been recovered", source.Name), http.StatusConflict)
+		return
+	}
+
+	lineage, err := a.db.GetLineage(source.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting lineage: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	plan := data.FailbackPlan{SourceID: source.ID, SourceName: source.Name, Links: []data.Lineage{}}
+	for _, link := range lineage {
+		if link.SourceID == source.ID && link.FailedBackAt == nil {
+			plan.Links = append(plan.Links, link)
+		}
+	}
+	if len(plan.Links) == 0 {
+		http.Error(w, fmt.Sprintf("VM %s has no volumes to fail back", source.Name), http.StatusConflict)
+		return
+	}
+
+	if r.URL.Query().Get("dry_run") == "true" {
+		token := common.GetToken()
+		steps := previewActions(a.failbackActions(nil, token, plan))
+		for _, link := range plan.Links {
+			if link.Kind == data.LineageRecreate {
+				steps = append(steps, data.DryRunStep{
+					Name:  "delete replacement " + link.ReplacementID,
+					Calls: []data.APICall{{Method: "DELETE", URL: common.BaseOpenstackUrl + "/compute/v2.1/servers/" + link.ReplacementID}},
+				})
+			}
+		}
+		rd.JSON(w, http.StatusOK, data.DryRunResult{Failback: &plan, Steps: steps})
+		return
+	}
+
+	job, err := newJob(data.JobKindFailback, source.ID, failbackReq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	job.Failback = &plan
+
+	err = a.holdForApproval(job, []*data.VMInstance{source}, failbackReq.Operator)
+	if errors.Is(err, errOperatorRequired) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Error checking approval policy: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	err = a.queueJob(job, failbackLockKeys(plan))
+	if err != nil {
+		if !writeLockConflict(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to queue failback: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	acceptJob(w, job)
+}
+
+func failbackLockKeys(plan data.FailbackPlan) []string {
+	recovery := data.RecoveryPlan{SourceID: plan.SourceID}
+	for _, link := range plan.Links {
+		recovery.Assignments = append(recovery.Assignments, data.Assignment{TargetID: link.ReplacementID})
+	}
+	return lockKeys([]data.RecoveryPlan{recovery})
+}
+
+func (a *AppHandler) runFailbackJob(run *jobRun) error {
+	if run.job.Failback == nil {
+		return fmt.Errorf("job has no failback plan")
+	}
+
+	token := common.GetToken()
+	err := run.execute(a.failbackActions(run, token, *run.job.Failback))
+	if err != nil {
+		return err
+	}
+
+	// A retired replacement is deleted so that a later recovery of the same
+	// VM can recreate it under the same name. This cannot be undone, so it
+	// happens only once the failback has been recorded.
+	for _, link := range run.job.Failback.Links {
+		if link.Kind != data.LineageRecreate {
+			continue
+		}
+		err := run.step("delete replacement "+link.ReplacementID, func() error {
+			return common.DeleteServer(token, link.ReplacementID)
+		})
+		if err != nil {
+			return fmt.Errorf("failback completed but deleting replacement %s failed: %v", link.ReplacementID, err)
+		}
+	}
+	return nil
+}
+
+// failbackActions unlocks the original, stops each recreated replacement,
+// frees the original's root volume when a replacement booted from it,
+// moves every volume back to the original, starts it, takes back the
+// recovery's placements and restores the inventory. The stopped replacements are deleted afterwards. Like a
+// recovery, a failing step is undone with the ones before it.
+func (a *AppHandler) failbackActions(run *jobRun, token string, plan data.FailbackPlan) []action {
+	source := data.RecoveryPlan{SourceID: plan.SourceID, SourceName: plan.SourceName}
+
+	unlocked := false
+	actions := []action{{
+		name: "unlock " + plan.SourceName,
+		do: func() error {
+			_, err := common.GetServer(token, plan.SourceID)
+			if err != nil {
+				return fmt.Errorf("error fetching original VM: %v", err)
+			}
+			err = common.ServerAction(token, plan.SourceID, map[string]interface{}{"unlock": nil})
+			unlocked = err == nil
+			return err
+		},
+		undo: func() error {
+			if !unlocked {
+				return nil
+			}
+			return common.ServerAction(token, plan.SourceID, map[string]interface{}{"lock": nil})
+		},
+		preview: func() []data.APICall {
+			return []data.APICall{serverActionCall(plan.SourceID, map[string]interface{}{"unlock": nil})}
+		},
+	}}
+
+	for _, link := range plan.Links {
+		link := link
+		replacement := data.RecoveryPlan{SourceID: link.ReplacementID, SourceName: link.ReplacementID}
+
+		if link.Kind == data.LineageRecreate {
+			stopped := false
+			actions = append(actions, action{
+				name: "stop replacement " + link.ReplacementID,
+				do: func() error {
+					server, err := common.GetServer(token, link.ReplacementID)
+					if err != nil || isStopped(server) {
+						return err
+					}
+					err = common.ServerAction(token, link.ReplacementID, map[string]interface{}{"os-stop": nil})
+					stopped = err == nil
+					return err
+				},
+				undo: func() error {
+					if !stopped {
+						return nil
+					}
+					return common.ServerAction(token, link.ReplacementID, map[string]interface{}{"os-start": nil})
+				},
+				preview: func() []data.APICall {
+					return []data.APICall{serverActionCall(link.ReplacementID, map[string]interface{}{"os-stop": nil})}
+				},
+			})
+		}
+
+		if link.RootVolume != "" {
+			actions = append(actions, returnRootAction(token, plan, link))
+		}
+
+		for _, volumeID := range byDevice(link.Volumes, link.Devices) {
+			volumeID := volumeID
+			attached := false
+			actions = append(actions, releaseAction(token, replacement, volumeID))
+			actions = append(actions, action{
+				name: fmt.Sprintf("attach volume %s to %s", volumeID, plan.SourceName),
+				do: func() error {
+					run.addTarget(plan.SourceID)
+					err := requireAvailable(token, volumeID)
+					if err != nil {
+						return err
+					}
+					err = attachOnDevice(run, token, plan.SourceID, volumeID, link.Devices[volumeID])
+					attached = err == nil
+					return err
+				},
+				undo: func() error {
+					if !attached {
+						return nil
+					}
+					return releaseAction(token, source, volumeID).do()
+				},
+				preview: func() []data.APICall {
+					return []data.APICall{attachCall(plan.SourceID, volumeID, link.Devices[volumeID])}
+				},
+			})
+		}
+	}
+
+	// A fence with reset_state leaves the original in ERROR, which os-start
+	// refuses. Nova can only reset it to active, and os-start refuses that
+	// too, so a reset server is brought up with a hard reboot instead.
+	resetBody := map[string]interface{}{"os-resetState": map[string]string{"state": "active"}}
+	rebootBody := map[string]interface{}{"reboot": map[string]string{"type": "HARD"}}
+	started := false
+	reset := false
+	actions = append(actions, action{
+		name: "start " + plan.SourceName,
+		do: func() error {
+			server, err := common.GetServer(token, plan.SourceID)
+			if err != nil || server.Status == "ACTIVE" {
+				return err
+			}
+			if server.Status == "ERROR" {
+				err = common.ServerAction(token, plan.SourceID, resetBody)
+				if err != nil {
+					return fmt.Errorf("error resetting state of %s: %v", plan.SourceName, err)
+				}
+				reset = true
+				err = common.ServerAction(token, plan.SourceID, rebootBody)
+			} else {
+				err = common.ServerAction(token, plan.SourceID, map[string]interface{}{"os-start": nil})
+			}
+			started = err == nil
+			return err
+		},
+		undo: func() error {
+			if started {
+				err := common.ServerAction(token, plan.SourceID, map[string]interface{}{"os-stop": nil})
+				if err != nil {
+					return err
+				}
+			}
+			if reset {
+				return common.ServerAction(token, plan.SourceID, map[string]interface{}{"os-resetState": map[string]string{"state": "error"}})
+			}
+			return nil
+		},
+		preview: func() []data.APICall {
+			server, err := common.GetServer(token, plan.SourceID)
+			if err == nil && server.Status == "ERROR" {
+				return []data.APICall{serverActionCall(plan.SourceID, resetBody), serverActionCall(plan.SourceID, rebootBody)}
+			}
+			return []data.APICall{serverActionCall(plan.SourceID, map[string]interface{}{"os-start": nil})}
+		},
+	})
+
+	return append(actions, a.unplaceAction(plan), a.failbackInventoryAction(run, plan))
+}
+
+// unplaceAction takes back the placements the recovery counted against the
+// domains of its consolidation targets, once per target. Undoing it counts
+// them again.
+func (a *AppHandler) unplaceAction(plan data.FailbackPlan) action {
+	var removed []string
+	return action{
+		name: "remove placements of " + plan.SourceName,
+		do: func() error {
+			counted := make(map[string]bool)
+			for _, link := range plan.Links {
+				if link.Kind != data.LineageConsolidate || counted[link.ReplacementID] {
+					continue
+				}
+				counted[link.ReplacementID] = true
+				if domain := a.domainOfVM(link.ReplacementID); domain != "" {
+					err := a.db.RemovePlacement(domain)
+					if err != nil {
+						return fmt.Errorf("error removing placement on %s: %v", domain, err)
+					}
+					removed = append(removed, domain)
+				}
+			}
+			return nil
+		},
+		undo: func() error {
+			for len(removed) > 0 {
+				domain := removed[len(removed)-1]
+				err := a.db.AddPlacement(domain)
+				if err != nil {
+					return fmt.Errorf("error restoring placement on %s: %v", domain, err)
+				}
+				removed = removed[:len(removed)-1]
+			}
+			return nil
+		},
+	}
+}
+
+// returnRootAction frees the original's root volume from the stopped
+// replacement that booted from it. The original still maps it as its boot
+// disk, so it only has to be released for the original to start. A root
+// volume cannot be attached back as the replacement's boot disk, so undoing
+// the release fails and leaves the rollback for an operator to repair.
+func returnRootAction(token string, plan data.FailbackPlan, link data.Lineage) action {
+	released := false
+	return action{
+		name: fmt.Sprintf("release root volume %s from %s", link.RootVolume, link.ReplacementID),
+		do: func() error {
+			var err error
+			released, err = releaseVolume(token, link.ReplacementID, link.RootVolume)
+			return err
+		},
+		undo: func() error {
+			if !released {
+				return nil
+			}
+			return fmt.Errorf("root volume %s was detached from %s and must be reattached as its boot disk by an operator",
+				link.RootVolume, link.ReplacementID)
+		},
+		preview: func() []data.APICall {
+			return []data.APICall{{
+				Method: "DELETE",
+				URL:    common.BaseOpenstackUrl + "/compute/v2.1/servers/" + link.ReplacementID + "/os-volume_attachments/" + link.RootVolume,
+				Note:   "returns the boot disk of " + plan.SourceName + "; falls back to deleting the Cinder attachment",
+			}}
+		},
+	}
+}
+
+// failbackInventoryAction moves the volumes back into the original's
+// software profile, clears its status, retires recreated replacements and
+// closes the lineage links.
+func (a *AppHandler) failbackInventoryAction(run *jobRun, plan data.FailbackPlan) action {
+	return action{
+		name: "update inventory",
+		do: func() error {
+			source, err := a.db.GetVMInfo(plan.SourceID)
+			if err != nil {
+				return err
+			}
+
+			var vms []data.VMInstance
+			for _, link := range plan.Links {
+				replacement, err := a.db.GetVMInfo(link.ReplacementID)
+				if err != nil {
+					return err
+				}
+				moved := takeVolumes(&replacement.Software, link.Volumes)
+				setDevices(&moved, source.ID, run.job.Devices)
+				mergeSoftware(&source.Software, moved)
+				if link.Kind == data.LineageRecreate {
+					replacement.Status = data.VMRetired
+				}
+				if link.RootVolume != "" && replacement.RootVolume == link.RootVolume {
+					replacement.RootVolume = ""
+				}
+				vms = append(vms, *replacement)
+			}
+
+			source.Status = ""
+			return a.db.RecordFailback(append([]data.VMInstance{*source}, vms...), plan.SourceID)
+		},
+	}
+}
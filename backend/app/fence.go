@@ -0,0 +1,155 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/common"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+const (
+	fenceTimeout      = time.Minute
+	fencePollInterval = 2 * time.Second
+)
+
+// fenceAction stops and locks the failed server before any of its volumes
+// move, so a VM that is only unreachable cannot keep writing to them. The
+// recovery is refused unless fencing is confirmed or the operator overrode
+// it with a reason. There is no compensation: after a rollback the source
+// stays fenced until an operator decides otherwise.
+func fenceAction(run *jobRun, token string, plan data.RecoveryPlan, recoverReq data.RecoverRequest) action {
+	return action{
+		name: "fence " + plan.SourceName,
+		do: func() error {
+			result := fenceServer(token, plan.SourceID, recoverReq.ResetState)
+			if !result.Confirmed && recoverReq.FenceOverride != nil {
+				result.Override = recoverReq.FenceOverride
+			}
+			run.setFencing(result)
+
+			if !result.Confirmed && result.Override == nil {
+				return fmt.Errorf("fencing of %s not confirmed (%s); retry with fence_override to proceed anyway", plan.SourceName, result.Detail)
+			}
+			return nil
+		},
+		preview: func() []data.APICall {
+			server, err := common.GetServer(token, plan.SourceID)
+			if err == common.ErrNotFound {
+				return nil
+			}
+			var calls []data.APICall
+			if err != nil || !isStopped(server) {
+				calls = append(calls, serverActionCall(plan.SourceID, map[string]interface{}{"os-stop": nil}))
+			}
+			if err != nil || !server.Locked {
+				calls = append(calls, serverActionCall(plan.SourceID, map[string]interface{}{"lock": nil}))
+			}
+			if recoverReq.ResetState {
+				calls = append(calls, serverActionCall(plan.SourceID, map[string]interface{}{"os-resetState": map[string]string{"state": "error"}}))
+			}
+			return calls
+		},
+	}
+}
+
+// fenceServer stops and locks the server, then waits for Nova to report it
+// stopped and locked. A server that no longer exists, or whose compute
+// service an operator has forced down, also counts as fenced. ERROR does
+// not: os-resetState only changes the Nova database and leaves the guest
+// running, so the state is read before any reset and the reset comes last.
+func fenceServer(token string, id string, resetState bool) data.FencingResult {
+	var result data.FencingResult
+	var problems []string
+
+	server, err := common.GetServer(token, id)
+	if err == common.ErrNotFound {
+		result.Confirmed = true
+		result.Detail = "server no longer exists"
+		return result
+	}
+	if err != nil {
+		result.Detail = fmt.Sprintf("error fetching server: %v", err)
+		return result
+	}
+
+	if !isStopped(server) {
+		if err := common.ServerAction(token, id, map[string]interface{}{"os-stop": nil}); err != nil {
+			problems = append(problems, fmt.Sprintf("stop failed: %v", err))
+		}
+	}
+	if !server.Locked {
+		if err := common.ServerAction(token, id, map[string]interface{}{"lock": nil}); err != nil {
+			problems = append(problems, fmt.Sprintf("lock failed: %v", err))
+		}
+	}
+
+	host := server.Host
+	deadline := time.Now().Add(fenceTimeout)
+	for {
+		server, err = common.GetServer(token, id)
+		if err == common.ErrNotFound {
+			result.Confirmed = true
+			problems = append(problems, "server no longer exists")
+			result.Detail = strings.Join(problems, "; ")
+			return result
+		}
+		if err == nil {
+			result.ServerStatus = server.Status
+			result.Locked = server.Locked
+			if isStopped(server) && server.Locked {
+				result.Confirmed = true
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(fencePollInterval)
+	}
+
+	if !result.Confirmed {
+		if forcedDown(token, host) {
+			result.Confirmed = true
+			problems = append(problems, fmt.Sprintf("compute service on %s is forced down", host))
+		} else {
+			problems = append(problems, fmt.Sprintf("server is %s and locked=%t after %s", result.ServerStatus, result.Locked, fenceTimeout))
+		}
+	}
+
+	// Resetting the state lets Nova act on a server whose host is down; it
+	// says nothing about the guest, so it never confirms fencing.
+	if resetState {
+		body := map[string]interface{}{"os-resetState": map[string]string{"state": "error"}}
+		if err := common.ServerAction(token, id, body); err != nil {
+			problems = append(problems, fmt.Sprintf("reset-state failed: %v", err))
+		}
+	}
+
+	result.Detail = strings.Join(problems, "; ")
+	return result
+}
+
+// isStopped reports whether Nova has seen the guest shut off.
+func isStopped(server data.ServerDetail) bool {
+	return server.Status == "SHUTOFF"
+}
+
+// forcedDown reports whether an operator has marked the compute service on
+// host as forced down, which Nova treats as the host being fenced.
+func forcedDown(token string, host string) bool {
+	if host == "" {
+		return false
+	}
+	services, err := common.GetComputeServices(token)
+	if err != nil {
+		return false
+	}
+	for _, service := range services {
+		if service.Host == host && service.ForcedDown {
+			return true
+		}
+	}
+	return false
+}
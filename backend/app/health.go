@@ -0,0 +1,264 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/common"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/model"
+)
+
+const healthInterval = 30 * time.Second
+
+const (
+	hostUp       = "up"
+	hostDown     = "down"
+	hostDisabled = "disabled"
+)
+
+type hostState struct {
+	state  string
+	detail string
+}
+
+// monitorHealth periodically checks every protected VM, and every server
+// the inventory does not know yet, and records its health. It only detects
+// failures; recovering is left to an operator.
+func (a *AppHandler) monitorHealth() {
+	for range time.Tick(healthInterval) {
+		a.checkHealth()
+	}
+}
+
+// checkHealth checks all VMs against a single listing of the servers.
+func (a *AppHandler) checkHealth() {
+	token := common.GetToken()
+
+	vms, err := a.db.GetVMsInfo()
+	if err != nil {
+		fmt.Printf("Error loading VMs for health check: %s\n", err)
+		return
+	}
+	hosts := hostStates(token)
+
+	servers, listErr := common.GetServers(token)
+	if listErr != nil {
+		fmt.Printf("Error listing servers for health check: %s\n", listErr)
+	}
+	byID := make(map[string]*data.ServerDetail)
+	for i := range servers {
+		byID[servers[i].ID] = &servers[i]
+	}
+
+	var checked []data.VMHealth
+	known := make(map[string]bool)
+	for _, vm := range vms {
+		known[vm.ID] = true
+		// Recovered and retired VMs have been replaced and are not watched.
+		if vm.Status != "" && vm.Status != data.VMFailed {
+			continue
+		}
+
+		health := newHealth(vm.ID, vm.Name, vm.Host)
+		if listErr != nil {
+			health.State = data.HealthUnknown
+			health.Detail = fmt.Sprintf("error listing servers: %v", listErr)
+		} else {
+			checkServer(&health, byID[vm.ID], hosts)
+		}
+		checked = append(checked, health)
+	}
+
+	// Servers created outside this service are watched too, until the
+	// inventory picks them up.
+	for _, server := range servers {
+		if known[server.ID] {
+			continue
+		}
+		server := server
+		health := newHealth(server.ID, server.Name, server.Host)
+		checkServer(&health, &server, hosts)
+		checked = append(checked, health)
+	}
+
+	watched := make(map[string]bool)
+	for _, health := range checked {
+		watched[health.VMID] = true
+
+		previous, err := a.db.GetHealth(health.VMID)
+		if err == nil && previous.State == health.State {
+			health.Since = previous.Since
+		} else {
+			fmt.Printf("VM %s is %s: %s\n", health.Name, health.State, health.Detail)
+		}
+
+		err = a.db.SetHealth(health)
+		if err != nil {
+			fmt.Printf("Error saving health of %s: %s\n", health.Name, err)
+		}
+	}
+
+	healths, err := a.db.GetHealths()
+	if err != nil {
+		fmt.Printf("Error loading health: %s\n", err)
+		return
+	}
+	for _, health := range healths {
+		if !watched[health.VMID] {
+			err := a.db.DeleteHealth(health.VMID)
+			if err != nil {
+				fmt.Printf("Error deleting health of %s: %s\n", health.VMID, err)
+			}
+		}
+	}
+}
+
+func newHealth(id string, name string, host string) data.VMHealth {
+	now := time.Now().UTC()
+	return data.VMHealth{
+		VMID:      id,
+		Name:      name,
+		Host:      host,
+		Since:     now,
+		CheckedAt: now,
+	}
+}
+
+// checkServer derives health from the Nova server, nil when it no longer
+// exists, and the state of the host it runs on. A server on a down host is
+// failed even while Nova still reports it ACTIVE, since Nova cannot see it
+// any more.
+func checkServer(health *data.VMHealth, server *data.ServerDetail, hosts map[string]hostState) {
+	if server == nil {
+		health.State = data.HealthFailed
+		health.Detail = "server no longer exists"
+		return
+	}
+
+	health.ServerStatus = server.Status
+	health.VMState = server.VMState
+	if server.Host != "" {
+		health.Host = server.Host
+	}
+	host, known := hosts[health.Host]
+	health.HostState = host.state
+
+	switch {
+	case server.Status == "ERROR" || server.VMState == "error":
+		health.State = data.HealthFailed
+		health.Detail = "server is in error state"
+	case known && host.state == hostDown:
+		health.State = data.HealthFailed
+		health.Detail = fmt.Sprintf("host %s: %s", health.Host, host.detail)
+	case server.Status == "ACTIVE":
+		health.State = data.HealthHealthy
+		if host.state == hostDisabled {
+			health.Detail = fmt.Sprintf("host %s: %s", health.Host, host.detail)
+		}
+	default:
+		health.State = data.HealthDegraded
+		health.Detail = fmt.Sprintf("server is %s, vm_state %s", server.Status, server.VMState)
+	}
+}
+
+// hostStates returns the state of each compute host from its nova-compute
+// service and hypervisor. Hosts are missing when they could not be read.
+func hostStates(token string) map[string]hostState {
+	hosts := make(map[string]hostState)
+
+	services, err := common.GetComputeServices(token)
+	if err != nil {
+		fmt.Printf("Error fetching compute services: %s\n", err)
+	}
+	for _, service := range services {
+		switch {
+		case service.ForcedDown:
+			hosts[service.Host] = hostState{hostDown, "compute service is forced down"}
+		case service.State == "down":
+			hosts[service.Host] = hostState{hostDown, "compute service is down"}
+		case service.Status == "disabled":
+			hosts[service.Host] = hostState{hostDisabled, "compute service is disabled"}
+		default:
+			hosts[service.Host] = hostState{hostUp, ""}
+		}
+	}
+
+	hypervisors, err := common.GetHypervisors(token)
+	if err != nil {
+		fmt.Printf("Error fetching hypervisors: %s\n", err)
+	}
+	for _, hypervisor := range hypervisors {
+		if hypervisor.State == "down" && hosts[hypervisor.Service.Host].state != hostDown {
+			hosts[hypervisor.Service.Host] = hostState{hostDown, "hypervisor is down"}
+		}
+	}
+
+	return hosts
+}
+
+func (a *AppHandler) getHealth(w http.ResponseWriter, r *http.Request) {
+	health, err := a.db.GetHealth(mux.Vars(r)["id"])
+	if errors.Is(err, model.ErrHealthNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("error getting health: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, health)
+}
+
+func (a *AppHandler) getHealthSummary(w http.ResponseWriter, r *http.Request) {
+	healths, err := a.db.GetHealths()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting health: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, healthSummary(healths))
+}
+
+func healthSummary(healths []data.VMHealth) data.HealthSummary {
+	summary := data.HealthSummary{
+		Total:     len(healths),
+		States:    make(map[string]int),
+		Hosts:     []data.HostHealth{},
+		Unhealthy: []data.VMHealth{},
+	}
+
+	hosts := make(map[string]*data.HostHealth)
+	for _, health := range healths {
+		summary.States[health.State]++
+		if health.State != data.HealthHealthy {
+			summary.Unhealthy = append(summary.Unhealthy, health)
+		}
+		if summary.CheckedAt == nil || health.CheckedAt.After(*summary.CheckedAt) {
+			checkedAt := health.CheckedAt
+			summary.CheckedAt = &checkedAt
+		}
+
+		host, ok := hosts[health.Host]
+		if !ok {
+			host = &data.HostHealth{Host: health.Host, States: make(map[string]int)}
+			hosts[health.Host] = host
+		}
+		if health.HostState != "" {
+			host.State = health.HostState
+		}
+		host.States[health.State]++
+	}
+
+	for _, host := range hosts {
+		summary.Hosts = append(summary.Hosts, *host)
+	}
+	sort.Slice(summary.Hosts, func(i, j int) bool {
+		return summary.Hosts[i].Host < summary.Hosts[j].Host
+	})
+	return summary
+}
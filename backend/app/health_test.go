@@ -0,0 +1,71 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+func TestHealthSummary(t *testing.T) {
+	earlier := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	later := earlier.Add(time.Minute)
+
+	tests := []struct {
+		name          string
+		healths       []data.VMHealth
+		wantStates    map[string]int
+		wantUnhealthy []string
+		wantHosts     []data.HostHealth
+		wantCheckedAt *time.Time
+	}{
+		{
+			name:          "no VMs",
+			wantStates:    map[string]int{},
+			wantHosts:     []data.HostHealth{},
+			wantUnhealthy: nil,
+		},
+		{
+			name: "grouped by host",
+			healths: []data.VMHealth{
+				{VMID: "a", Host: "h2", HostState: hostUp, State: data.HealthHealthy, CheckedAt: earlier},
+				{VMID: "b", Host: "h1", HostState: hostDown, State: data.HealthFailed, CheckedAt: later},
+				{VMID: "c", Host: "h2", State: data.HealthDegraded, CheckedAt: earlier},
+			},
+			wantStates:    map[string]int{data.HealthHealthy: 1, data.HealthFailed: 1, data.HealthDegraded: 1},
+			wantUnhealthy: []string{"b", "c"},
+			wantHosts: []data.HostHealth{
+				{Host: "h1", State: hostDown, States: map[string]int{data.HealthFailed: 1}},
+				{Host: "h2", State: hostUp, States: map[string]int{data.HealthHealthy: 1, data.HealthDegraded: 1}},
+			},
+			wantCheckedAt: &later,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary := healthSummary(tt.healths)
+
+			if summary.Total != len(tt.healths) {
+				t.Errorf("Total = %d, want %d", summary.Total, len(tt.healths))
+			}
+			if !reflect.DeepEqual(summary.States, tt.wantStates) {
+				t.Errorf("States = %v, want %v", summary.States, tt.wantStates)
+			}
+			var unhealthy []string
+			for _, health := range summary.Unhealthy {
+				unhealthy = append(unhealthy, health.VMID)
+			}
+			if !reflect.DeepEqual(unhealthy, tt.wantUnhealthy) {
+				t.Errorf("Unhealthy = %v, want %v", unhealthy, tt.wantUnhealthy)
+			}
+			if !reflect.DeepEqual(summary.Hosts, tt.wantHosts) {
+				t.Errorf("Hosts = %v, want %v", summary.Hosts, tt.wantHosts)
+			}
+			if !reflect.DeepEqual(summary.CheckedAt, tt.wantCheckedAt) {
+				t.Errorf("CheckedAt = %v, want %v", summary.CheckedAt, tt.wantCheckedAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+const idempotencyHeader = "Idempotency-Key"
+
+// idempotencyReservationTimeout is how long a request may hold its key
+// before a retry assumes it died and runs instead.
+const idempotencyReservationTimeout = 5 * time.Minute
+
+// responseCapture records what a handler writes so it can be stored.
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (c *responseCapture) WriteHeader(status int) {
+	c.status = status
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *responseCapture) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+// withIdempotency makes a job-creating POST handler safe to retry. The
+// first request with a given Idempotency-Key runs normally and, if it
+// queues a job, its response is stored with a hash of the request. Repeats
+// with the same key get the stored response back instead of a second job;
+// reusing a key for a different request is rejected.
+func (a *AppHandler) withIdempotency(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			h(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.String()+"\n"), body...))
+		requestHash := hex.EncodeToString(sum[:])
+
+		record, err := a.db.ReserveIdempotencyKey(key, requestHash, time.Now().UTC().Add(-idempotencyReservationTimeout))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if record != nil {
+			switch {
+			case record.RequestHash != requestHash:
+				http.Error(w, fmt.Sprintf("%s %s was already used for a different request", idempotencyHeader, key), http.StatusUnprocessableEntity)
+			case record.StatusCode == 0:
+				rd.JSON(w, http.StatusConflict, data.ConflictResponse{Error: fmt.Sprintf("a request with %s %s is still in progress", idempotencyHeader, key)})
+			default:
+				if record.JobID != "" {
+					w.Header().Set("Location", "/jobs/"+record.JobID)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+				w.WriteHeader(record.StatusCode)
+				w.Write(record.Response)
+			}
+			return
+		}
+
+		capture := &responseCapture{ResponseWriter: w}
+		h(capture, r)
+
+		if capture.status != http.StatusAccepted {
+			if err := a.db.ReleaseIdempotencyKey(key); err != nil {
+				fmt.Printf("Error releasing idempotency key %s: %s\n", key, err)
+			}
+			return
+		}
+
+		var accepted data.JobAccepted
+		json.Unmarshal(capture.body.Bytes(), &accepted)
+		err = a.db.CompleteIdempotencyKey(key, accepted.JobID, capture.status, capture.body.Bytes())
+		if err != nil {
+			fmt.Printf("Error storing idempotent response for %s: %s\n", key, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/common"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+// inventoryAction records a finished recovery in the inventory: the moved
+// volumes leave the failed VM's software profile for their new VM, a
+// recreated server is added, the source is marked recovered and each
+// replacement is linked to it. It runs last, so a failure rolls back the
+// OpenStack changes and both sides stay in agreement.
+func (a *AppHandler) inventoryAction(run *jobRun, token string, plan data.RecoveryPlan) action {
+	return action{
+		name: "update inventory",
+		do: func() error {
+			source, err := a.db.GetVMInfo(plan.SourceID)
+			if err != nil {
+				return err
+			}
+
+			now := time.Now().UTC()
+			updated := make(map[string]*data.VMInstance)
+			var order []string
+			var lineage []data.Lineage
+
+			for _, assignment := range plan.Assignments {
+				target, ok := updated[assignment.TargetID]
+				if !ok {
+					target, err = a.db.GetVMInfo(assignment.TargetID)
+					if err != nil {
+						return err
+					}
+					updated[target.ID] = target
+					order = append(order, target.ID)
+				}
+				moved := takeVolumes(&source.Software, assignment.Volumes)
+				setDevices(&moved, target.ID, run.job.Devices)
+				mergeSoftware(&target.Software, moved)
+				lineage = append(lineage, data.Lineage{
+					SourceID:      source.ID,
+					ReplacementID: target.ID,
+					JobID:         run.job.ID,
+					Kind:          data.LineageConsolidate,
+					Volumes:       assignment.Volumes,
+					CreatedAt:     now,
+					Devices:       devicesOf(assignment.Volumes, plan.Devices),
+				})
+			}
+
+			if plan.Recreate != nil {
+				serverID, err := run.recreatedServer()
+				if err != nil {
+					return err
+				}
+				server, err := common.GetServer(token, serverID)
+				if err != nil {
+					return fmt.Errorf("error fetching recreated VM: %v", err)
+				}
+				recreated := &data.VMInstance{
+					ID:               server.ID,
+					Name:             server.Name,
+					FlavorID:         plan.Recreate.FlavorID,
+					OS:               plan.Recreate.OS,
+					Software:         takeVolumes(&source.Software, plan.Recreate.Volumes),
+					Strategy:         source.Strategy,
+					TargetRTO:        source.TargetRTO,
+					Host:             server.Host,
+					AvailabilityZone: server.AvailabilityZone,
+					ServerGroups:     server.ServerGroups,
+					Attributes:       common.Attributes(server),
+				}
+				recreated.Networks, err = common.GetServerNetworks(token, server.ID)
+				if err != nil {
+					return fmt.Errorf("error fetching networks of the recreated VM: %v", err)
+				}
+				root, err := common.RootVolume(token, server)
+				if err != nil {
+					return fmt.Errorf("error finding root volume of the recreated VM: %v", err)
+				}
+				recreated.RootVolume = root.ID
+				setDevices(&recreated.Software, recreated.ID, run.job.Devices)
+				updated[recreated.ID] = recreated
+				order = append(order, recreated.ID)
+				link := data.Lineage{
+					SourceID:      source.ID,
+					ReplacementID: recreated.ID,
+					JobID:         run.job.ID,
+					Kind:          data.LineageRecreate,
+					Volumes:       plan.Recreate.Volumes,
+					CreatedAt:     now,
+					Devices:       devicesOf(plan.Recreate.Volumes, plan.Devices),
+				}
+				// A replacement booted from the original root volume rather
+				// than a clone has to give it back on failback.
+				if root.ID == plan.Recreate.RootVolume {
+					link.RootVolume = root.ID
+				}
+				lineage = append(lineage, link)
+			}
+
+			source.Status = data.VMRecovered
+			vms := []data.VMInstance{*source}
+			for _, id := range order {
+				vms = append(vms, *updated[id])
+			}
+
+			return a.db.RecordRecovery(vms, lineage)
+		},
+	}
+}
+
+// takeVolumes removes the volumes with the given IDs from software and
+// returns them, keeping their categories.
+func takeVolumes(software *data.Software, ids []string) data.Software {
+	wanted := toSet(ids)
+	var taken data.Software
+	software.Languages, taken.Languages = splitVolumes(software.Languages, wanted)
+	software.Databases, taken.Databases = splitVolumes(software.Databases, wanted)
+	software.Webservers, taken.Webservers = splitVolumes(software.Webservers, wanted)
+	return taken
+}
+
+// devicesOf returns the devices of the volumes with the given IDs, or nil
+// when none of them has one.
+func devicesOf(ids []string, devices map[string]string) map[string]string {
+	var found map[string]string
+	for _, id := range ids {
+		if device, ok := devices[id]; ok {
+			if found == nil {
+				found = make(map[string]string)
+			}
+			found[id] = device
+		}
+	}
+	return found
+}
+
+// setDevices records the devices the volumes in software got on serverID.
+func setDevices(software *data.Software, serverID string, mappings []data.DeviceMapping) {
+	devices := make(map[string]string)
+	for _, mapping := range mappings {
+		if mapping.ServerID == serverID {
+			devices[mapping.VolumeID] = mapping.Device
+		}
+	}
+	for _, group := range [][]data.Volume{software.Languages, software.Databases, software.Webservers} {
+		for i := range group {
+			if device, ok := devices[group[i].ID]; ok {
+				group[i].Device = device
+			}
+		}
+	}
+}
+
+func splitVolumes(volumes []data.Volume, wanted map[string]bool) ([]data.Volume, []data.Volume) {
+	kept := []data.Volume{}
+	var taken []data.Volume
+	for _, v := range volumes {
+		if wanted[v.ID] {
+			taken = append(taken, v)
+		} else {
+			kept = append(kept, v)
+		}
+	}
+	return kept, taken
+}
+
+func mergeSoftware(dst *data.Software, src data.Software) {
+	dst.Languages = append(dst.Languages, src.Languages...)
+	dst.Databases = append(dst.Databases, src.Databases...)
+	dst.Webservers = append(dst.Webservers, src.Webservers...)
+}
+
+func (a *AppHandler) getLineage(w http.ResponseWriter, r *http.Request) {
+	lineage, err := a.db.GetLineage(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting lineage: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, lineage)
+}
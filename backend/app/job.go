@@ -0,0 +1,551 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/model"
+)
+
+const (
+	jobWorkers   = 4
+	jobQueueSize = 256
+
+	// A running job whose owner has not sent a heartbeat for jobStaleAfter
+	// is taken to have lost its process.
+	jobHeartbeatInterval = 15 * time.Second
+	jobStaleAfter        = 2 * time.Minute
+)
+
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	s := hex.EncodeToString(b)
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:32]
+}
+
+func newJob(kind string, vmID string, request interface{}) (*data.Job, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling job request: %v", err)
+	}
+
+	now := time.Now().UTC()
+	return &data.Job{
+		ID:        newJobID(),
+		Kind:      kind,
+		VMID:      vmID,
+		Status:    data.JobQueued,
+		Request:   body,
+		Targets:   []string{},
+		Steps:     []data.JobStep{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, nil
+}
+
+// newInstanceID names this process in the jobs it owns.
+func newInstanceID() string {
+	host, _ := os.Hostname()
+	return host + "-" + newJobID()[:8]
+}
+
+// startWorkers resumes jobs left over from a previous run and starts the
+// workers. Jobs that were running cannot be resumed safely, since their
+// OpenStack calls may or may not have gone through, so once their owner
+// stops sending heartbeats they are marked interrupted for an operator to
+// inspect. Jobs running on other live replicas are left alone.
+func (a *AppHandler) startWorkers() {
+	a.queue = make(chan string, jobQueueSize)
+
+	a.interruptStaleJobs()
+
+	for i := 0; i < jobWorkers; i++ {
+		go a.worker()
+	}
+	go a.heartbeat()
+	go a.expireApprovals()
+	go a.monitorHealth()
+
+	queued, err := a.db.GetJobsByStatus(data.JobQueued)
+	if err != nil {
+		fmt.Printf("Error loading queued jobs: %s\n", err)
+	}
+	for _, job := range queued {
+		if job.ParentID == "" {
+			a.queue <- job.ID
+		}
+	}
+}
+
+// heartbeat keeps the jobs of this process from being taken as stale and
+// interrupts those of processes that stopped.
+func (a *AppHandler) heartbeat() {
+	for range time.Tick(jobHeartbeatInterval) {
+		if err := a.db.HeartbeatJobs(a.instance); err != nil {
+			fmt.Printf("Error sending job heartbeat: %s\n", err)
+		}
+		a.interruptStaleJobs()
+	}
+}
+
+func (a *AppHandler) interruptStaleJobs() {
+	ids, err := a.db.InterruptStaleJobs(time.Now().UTC().Add(-jobStaleAfter), "service stopped while the job was running")
+	if err != nil {
+		fmt.Printf("Error interrupting stale jobs: %s\n", err)
+	}
+	for _, id := range ids {
+		fmt.Printf("Job %s interrupted: its owner stopped sending heartbeats\n", id)
+	}
+}
+
+func (a *AppHandler) worker() {
+	for id := range a.queue {
+		a.runJob(id)
+	}
+}
+
+// submitJob persists job and queues it for a worker.
+func (a *AppHandler) submitJob(job *data.Job) error {
+	err := a.db.CreateJob(*job)
+	if err != nil {
+		return err
+	}
+	a.queue <- job.ID
+	return nil
+}
+
+func (a *AppHandler) runJob(id string) {
+	job, err := a.db.ClaimJob(id, a.instance)
+	if errors.Is(err, model.ErrJobNotQueued) {
+		return
+	} else if err != nil {
+		fmt.Printf("Error claiming job %s: %s\n", id, err)
+		return
+	}
+
+	run := &jobRun{a: a, job: job}
+
+	// Jobs queued before a restart lost the locks taken by their request,
+	// and approved jobs never held any.
+	// Per-VM child jobs run under the locks of their parent.
+	if job.ParentID == "" {
+		keys, err := a.jobLockKeys(job)
+		if err == nil {
+			err = a.lockJob(job, keys)
+		}
+		if err != nil {
+			run.finish(err)
+			return
+		}
+		defer a.unlockJob(job.ID)
+	}
+
+	switch job.Kind {
+	case data.JobKindRecover:
+		err = a.runRecoverJob(run)
+	case data.JobKindCreate:
+		err = a.runCreateJob(run)
+	case data.JobKindBulk:
+		err = a.runBulkJob(run)
+	case data.JobKindPlan:
+		err = a.runPlanJob(run)
+	case data.JobKindFailback:
+		err = a.runFailbackJob(run)
+	default:
+		err = fmt.Errorf("unknown job kind: %s", job.Kind)
+	}
+
+	run.finish(err)
+}
+
+func (a *AppHandler) runRecoverJob(run *jobRun) error {
+	if run.job.Plan == nil {
+		return fmt.Errorf("job has no recovery plan")
+	}
+
+	var recoverReq data.RecoverRequest
+	err := json.Unmarshal(run.job.Request, &recoverReq)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling recover request: %v", err)
+	}
+
+	err = a.executePlan(run, *run.job.Plan, recoverReq)
+	if err != nil {
+		return err
+	}
+
+	a.validateRecovery(run, *run.job.Plan)
+	return nil
+}
+
+func (a *AppHandler) runCreateJob(run *jobRun) error {
+	var instanceReq data.InstanceRequest
+	err := json.Unmarshal(run.job.Request, &instanceReq)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling create request: %v", err)
+	}
+
+	err = run.step("generate terraform script", func() error {
+		return generateTerraformScript(instanceReq.Name, instanceReq.OS, instanceReq.Ram, instanceReq.Vcpus, instanceReq.Disk, instanceReq.Volumes)
+	})
+	if err != nil {
+		return err
+	}
+
+	return run.step("terraform apply", runTerraformApply)
+}
+
+// jobLocks holds the advisory locks of in-flight jobs until they finish.
+type jobLocks struct {
+	mu   sync.Mutex
+	held map[string]model.Lock
+}
+
+func newJobLocks() *jobLocks {
+	return &jobLocks{held: make(map[string]model.Lock)}
+}
+
+// lockKeys lists what recovering plans must hold exclusively: each failed
+// VM and every VM receiving its volumes.
+func lockKeys(plans []data.RecoveryPlan) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	add := func(id string) {
+		key := "vm:" + id
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	for _, plan := range plans {
+		add(plan.SourceID)
+		for _, assignment := range plan.Assignments {
+			add(assignment.TargetID)
+		}
+	}
+	return keys
+}
+
+// hasChildren reports whether jobs of kind run per-VM child jobs.
+func hasChildren(kind string) bool {
+	return kind == data.JobKindBulk || kind == data.JobKindPlan
+}
+
+// jobLockKeys returns the lock keys of what a job changes, including the
+// recovery plans of its per-VM child jobs.
+func (a *AppHandler) jobLockKeys(job *data.Job) ([]string, error) {
+	if job.Failback != nil {
+		return failbackLockKeys(*job.Failback), nil
+	}
+
+	var plans []data.RecoveryPlan
+	if job.Plan != nil {
+		plans = append(plans, *job.Plan)
+	}
+	if hasChildren(job.Kind) {
+		children, err := a.db.GetChildJobs(job.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			if child.Plan != nil {
+				plans = append(plans, *child.Plan)
+			}
+		}
+	}
+	return lockKeys(plans), nil
+}
+
+// lockJob acquires the lock keys on behalf of job unless it already holds
+// them. A *model.LockedError names the job holding a conflicting lock.
+func (a *AppHandler) lockJob(job *data.Job, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	a.locks.mu.Lock()
+	_, held := a.locks.held[job.ID]
+	a.locks.mu.Unlock()
+	if held {
+		return nil
+	}
+
+	lock, err := a.db.AcquireLocks(job.ID, keys)
+	if err != nil {
+		return err
+	}
+
+	a.locks.mu.Lock()
+	a.locks.held[job.ID] = lock
+	a.locks.mu.Unlock()
+	return nil
+}
+
+func (a *AppHandler) unlockJob(id string) {
+	a.locks.mu.Lock()
+	lock, ok := a.locks.held[id]
+	delete(a.locks.held, id)
+	a.locks.mu.Unlock()
+
+	if ok {
+		lock.Release()
+	}
+}
+
+// writeLockConflict answers 409 when err is a lock conflict and reports
+// whether it did.
+func writeLockConflict(w http.ResponseWriter, err error) bool {
+	var locked *model.LockedError
+	if !errors.As(err, &locked) {
+		return false
+	}
+	rd.JSON(w, http.StatusConflict, data.ConflictResponse{Error: locked.Error(), JobID: locked.JobID})
+	return true
+}
+
+// jobRun records the progress of a job as its steps execute.
+type jobRun struct {
+	a   *AppHandler
+	job *data.Job
+	mu  sync.Mutex
+
+	// recreated is the server a recovery created for the failed VM.
+	recreated string
+}
+
+func (r *jobRun) save() {
+	if err := r.a.db.UpdateJob(*r.job); err != nil {
+		fmt.Printf("Error saving job %s: %s\n", r.job.ID, err)
+	}
+}
+
+// step runs fn as a named step and persists its state before and after.
+func (r *jobRun) step(name string, fn func() error) error {
+	_, err := r.runStep(name, fn)
+	return err
+}
+
+func (r *jobRun) runStep(name string, fn func() error) (int, error) {
+	r.mu.Lock()
+	r.job.Steps = append(r.job.Steps, data.JobStep{Name: name, Status: data.StepRunning, StartedAt: time.Now().UTC()})
+	i := len(r.job.Steps) - 1
+	r.save()
+	r.mu.Unlock()
+
+	err := fn()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	r.job.Steps[i].FinishedAt = &now
+	if err != nil {
+		r.job.Steps[i].Status = data.StepFailed
+		r.job.Steps[i].Error = err.Error()
+	} else {
+		r.job.Steps[i].Status = data.StepSucceeded
+	}
+	r.save()
+
+	return i, err
+}
+
+// action is one mutating step of a job together with the compensating
+// action that undoes it. undo is nil when there is nothing to undo. undo
+// also runs when do fails, so it must reverse only what do completed,
+// tracking that in the closure, and do nothing when do changed nothing.
+// preview lists the mutating calls do would make given the current state,
+// using read-only requests only.
+type action struct {
+	name    string
+	do      func() error
+	undo    func() error
+	preview func() []data.APICall
+}
+
+// execute runs actions in order. When one fails, the compensating actions of
+// the failed action and every action before it run in reverse order and
+// their outcome is recorded on the job before the original error is
+// returned. Compensating the failed action undoes whatever part of it had
+// already taken effect.
+func (r *jobRun) execute(actions []action) error {
+	var done []int
+
+	for n, act := range actions {
+		i, err := r.runStep(act.name, act.do)
+		done = append(done, i)
+		if err != nil {
+			r.rollback(actions[:n+1], done)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rollback undoes actions in reverse order; steps holds the job step index
+// recorded for each of them.
+func (r *jobRun) rollback(actions []action, steps []int) {
+	outcome := data.RollbackCompleted
+
+	for j := len(actions) - 1; j >= 0; j-- {
+		if actions[j].undo == nil {
+			continue
+		}
+		err := actions[j].undo()
+
+		r.mu.Lock()
+		i := steps[j]
+		if err != nil {
+			r.job.Steps[i].Compensation = data.StepFailed
+			r.job.Steps[i].CompensationError = err.Error()
+			outcome = data.RollbackIncomplete
+		} else {
+			r.job.Steps[i].Compensation = data.StepSucceeded
+		}
+		r.save()
+		r.mu.Unlock()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.job.Rollback = outcome
+	r.save()
+}
+
+func (r *jobRun) setFencing(result data.FencingResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.job.Fencing = &result
+	r.save()
+}
+
+func (r *jobRun) setValidation(results []data.ValidationResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.job.Validation = results
+	r.save()
+}
+
+func (r *jobRun) addTarget(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.job.Targets {
+		if t == id {
+			return
+		}
+	}
+	r.job.Targets = append(r.job.Targets, id)
+}
+
+func (r *jobRun) setRecreated(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recreated = id
+}
+
+func (r *jobRun) recreatedServer() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.recreated == "" {
+		return "", fmt.Errorf("no server was recreated")
+	}
+	return r.recreated, nil
+}
+
+func (r *jobRun) addDevice(mapping data.DeviceMapping) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.job.Devices = append(r.job.Devices, mapping)
+}
+
+func (r *jobRun) finish(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	r.job.FinishedAt = &now
+	if err != nil {
+		r.job.Status = data.JobFailed
+		if r.job.Rollback == data.RollbackCompleted {
+			r.job.Status = data.JobRolledBack
+		}
+		r.job.Error = err.Error()
+	} else if validationFailed(r.job.Validation) {
+		r.job.Status = data.JobDegraded
+		r.job.Error = "recovered, but post-recovery validation failed"
+	} else {
+		r.job.Status = data.JobSucceeded
+	}
+	r.save()
+}
+
+func validationFailed(results []data.ValidationResult) bool {
+	for _, result := range results {
+		if !result.Passed {
+			return true
+		}
+	}
+	return false
+}
+
+func acceptJob(w http.ResponseWriter, job *data.Job) {
+	location := "/jobs/" + job.ID
+	w.Header().Set("Location", location)
+	rd.JSON(w, http.StatusAccepted, data.JobAccepted{
+		JobID:    job.ID,
+		Status:   job.Status,
+		Location: location,
+		Plan:     job.Plan,
+		Failback: job.Failback,
+	})
+}
+
+func (a *AppHandler) getJob(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	job, err := a.db.GetJob(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if hasChildren(job.Kind) {
+		children, err := a.db.GetChildJobs(job.ID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error getting child jobs: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for _, child := range children {
+			job.Children = append(job.Children, data.JobSummary{
+				ID:      child.ID,
+				VMID:    child.VMID,
+				Status:  child.Status,
+				Targets: child.Targets,
+				Error:   child.Error,
+			})
+		}
+	}
+
+	rd.JSON(w, http.StatusOK, job)
+}
+
+func (a *AppHandler) getJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := a.db.GetJobs(100)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting jobs: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, jobs)
+}
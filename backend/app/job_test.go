@@ -0,0 +1,43 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+func TestLockKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		plans []data.RecoveryPlan
+		want  []string
+	}{
+		{
+			name: "no plans",
+			want: nil,
+		},
+		{
+			name:  "recreate only",
+			plans: []data.RecoveryPlan{{SourceID: "s1"}},
+			want:  []string{"vm:s1"},
+		},
+		{
+			name: "sources and targets once each",
+			plans: []data.RecoveryPlan{
+				{SourceID: "s1", Assignments: []data.Assignment{{TargetID: "t1"}, {TargetID: "t2"}, {TargetID: "t1"}}},
+				{SourceID: "s2", Assignments: []data.Assignment{{TargetID: "t1"}}},
+			},
+			want: []string{"vm:s1", "vm:t1", "vm:t2", "vm:s2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lockKeys(tt.plans)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lockKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
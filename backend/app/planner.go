@@ -0,0 +1,541 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/common"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+const (
+	failureDomainSetting = "failure_domain"
+	domainHost           = "host"
+	domainZone           = "availability_zone"
+
+	maxVolumesSetting = "max_volumes_per_vm"
+	defaultMaxVolumes = 25
+
+	recoveryModeSetting = "recovery_mode"
+	modeSingle          = "single"
+	modeHybrid          = "hybrid"
+
+	categoryLanguage  = "language"
+	categoryDatabase  = "database"
+	categoryWebserver = "webserver"
+)
+
+// spreadPenalty is subtracted from a candidate's score for every recovery
+// its failure domain already took, so that nearly as good targets in other
+// domains win before one domain absorbs every failed VM.
+const spreadPenalty = 0.05
+
+var categories = []string{categoryLanguage, categoryDatabase, categoryWebserver}
+
+// planner chooses consolidation targets for failed VMs. It never picks a VM
+// sharing the failed VM's failure domain, penalises busy candidates by the
+// load weight and, among candidates that clear the similarity threshold,
+// penalises domains by the recoveries they already took.
+type planner struct {
+	weights      data.Weight
+	domain       string
+	vms          []*data.VMInstance
+	strategy     func(*data.VMInstance) SimilarityStrategy
+	placed       map[string]int
+	antiAffinity map[string]bool
+	usage        map[string]data.Usage
+	flavors      map[string]data.FlavorDetail
+	failed       map[string]bool
+	attached     map[string]int
+	maxVolumes   int
+
+	// hypervisors is only loaded when recreations must be placed against
+	// compute capacity; nil means capacity is unknown and not checked.
+	hypervisors []*data.Hypervisor
+	failedHosts map[string]bool
+}
+
+func (a *AppHandler) newPlanner(vms []*data.VMInstance) (*planner, error) {
+	weights, err := a.db.GetWeight()
+	if err != nil {
+		return nil, err
+	}
+
+	domain, err := a.db.GetSetting(failureDomainSetting)
+	if err != nil {
+		return nil, err
+	}
+	if domain != domainZone {
+		domain = domainHost
+	}
+
+	maxVolumes := defaultMaxVolumes
+	if v, err := a.db.GetSetting(maxVolumesSetting); err == nil && v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxVolumes = n
+		}
+	}
+
+	usage := make(map[string]data.Usage)
+	if a.metrics != nil {
+		var ids []string
+		for _, vm := range vms {
+			ids = append(ids, vm.ID)
+		}
+		// Scoring falls back to similarity alone rather than blocking a
+		// recovery on the metrics backend.
+		u, err := a.metrics.Usage(ids)
+		if err != nil {
+			fmt.Printf("Error fetching VM usage: %s\n", err)
+		} else {
+			usage = u
+		}
+	}
+
+	failed := make(map[string]bool)
+	for _, vm := range vms {
+		if vm.Status != "" {
+			failed[vm.ID] = true
+		}
+	}
+
+	placed, err := a.db.GetPlacements()
+	if err != nil {
+		return nil, err
+	}
+
+	// Usage is relative to each VM's own flavor, so it can only be added
+	// up across VMs once scaled by their sizes.
+	flavors := make(map[string]data.FlavorDetail)
+	if len(usage) > 0 {
+		flavors = flavorsOf(vms)
+	}
+
+	return &planner{
+		weights:      weights,
+		domain:       domain,
+		vms:          vms,
+		strategy:     a.strategyFor,
+		placed:       placed,
+		antiAffinity: antiAffinityGroups(vms),
+		usage:        usage,
+		flavors:      flavors,
+		failed:       failed,
+		attached:     make(map[string]int),
+		maxVolumes:   maxVolumes,
+		failedHosts:  make(map[string]bool),
+	}, nil
+}
+
+// newFailurePlanner returns a planner for recovering several failed VMs
+// together, none of which, nor anything on the failed hosts, is offered as
+// a target.
+func (a *AppHandler) newFailurePlanner(vms []*data.VMInstance, failedVMs []*data.VMInstance, failedHosts []string) (*planner, error) {
+	p, err := a.newPlanner(vms)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, vm := range failedVMs {
+		p.fail(vm)
+		if vm.Host != "" {
+			p.failedHosts[vm.Host] = true
+		}
+	}
+	for _, host := range failedHosts {
+		p.failedHosts[host] = true
+	}
+
+	hypervisors, err := common.GetHypervisors(common.GetToken())
+	if err != nil {
+		fmt.Printf("Error fetching hypervisors, recreations will not be checked against capacity: %s\n", err)
+	} else {
+		p.hypervisors = []*data.Hypervisor{}
+		for i := range hypervisors {
+			p.hypervisors = append(p.hypervisors, &hypervisors[i])
+		}
+	}
+
+	return p, nil
+}
+
+// planAll plans the recovery of each of vms in turn, reserving hypervisor
+// capacity for every recreation so that no two of them count on the same
+// room. A VM whose recreation fits on no surviving hypervisor gets no plan;
+// its error is returned by VM ID instead, and the others are still planned.
+func (p *planner) planAll(vms []*data.VMInstance, mode string) (map[string]data.RecoveryPlan, map[string]error) {
+	plans := make(map[string]data.RecoveryPlan)
+	failures := make(map[string]error)
+	for _, vm := range vms {
+		plan := p.plan(vm, mode)
+		if plan.Recreate != nil && p.hypervisors != nil {
+			flavor, err := p.flavor(vm.FlavorID)
+			if err != nil {
+				fmt.Printf("Error fetching flavor %s, capacity for %s is not checked: %s\n", vm.FlavorID, vm.Name, err)
+			} else if _, ok := p.placeRecreate(flavor); !ok {
+				failures[vm.ID] = fmt.Errorf("no surviving hypervisor fits flavor %s to recreate %s", flavor.Name, vm.Name)
+				continue
+			}
+		}
+		plans[vm.ID] = plan
+	}
+	return plans, failures
+}
+
+// flavor returns the flavor with the given ID, fetching it once.
+func (p *planner) flavor(id string) (data.FlavorDetail, error) {
+	if flavor, ok := p.flavors[id]; ok {
+		return flavor, nil
+	}
+	flavor, err := common.GetFlavor(common.GetToken(), id)
+	if err != nil {
+		return data.FlavorDetail{}, err
+	}
+	p.flavors[id] = flavor
+	return flavor, nil
+}
+
+// fail marks vm as down so it is never offered as a candidate.
+func (p *planner) fail(vm *data.VMInstance) {
+	p.failed[vm.ID] = true
+}
+
+// flavorsOf returns the flavors of vms by ID. Flavors that cannot be read
+// are left out.
+func flavorsOf(vms []*data.VMInstance) map[string]data.FlavorDetail {
+	flavors := make(map[string]data.FlavorDetail)
+	var token string
+	for _, vm := range vms {
+		if vm.FlavorID == "" {
+			continue
+		}
+		if _, ok := flavors[vm.FlavorID]; ok {
+			continue
+		}
+		if token == "" {
+			token = common.GetToken()
+		}
+		flavor, err := common.GetFlavor(token, vm.FlavorID)
+		if err != nil {
+			fmt.Printf("Error fetching flavor %s, its usage is not scaled: %s\n", vm.FlavorID, err)
+			continue
+		}
+		flavors[vm.FlavorID] = flavor
+	}
+	return flavors
+}
+
+// hasCapacity reports whether target can take the volumes and load of
+// source on top of what earlier placements in this plan consumed.
+func (p *planner) hasCapacity(source, target *data.VMInstance) bool {
+	volumes := len(allVolumes(target)) + p.attached[target.ID] + len(nonOverlappingVolumes(source, target))
+	if volumes > p.maxVolumes {
+		return false
+	}
+
+	u := addUsage(p.usage[target.ID], p.movedUsage(source, target))
+	return u.CPU <= 1 && u.Memory <= 1 && u.DiskIO <= 1
+}
+
+// movedUsage returns the usage of source in terms of target's flavor: a
+// busy small VM adds less than its own utilisation to a larger target. Disk
+// I/O is a share of time rather than of a flavor resource and is not
+// scaled, nor is anything when either flavor is unknown.
+func (p *planner) movedUsage(source, target *data.VMInstance) data.Usage {
+	u := p.usage[source.ID]
+	from, okFrom := p.flavors[source.FlavorID]
+	to, okTo := p.flavors[target.FlavorID]
+	if !okFrom || !okTo {
+		return u
+	}
+	if from.Vcpus > 0 && to.Vcpus > 0 {
+		u.CPU *= float64(from.Vcpus) / float64(to.Vcpus)
+	}
+	if from.Ram > 0 && to.Ram > 0 {
+		u.Memory *= float64(from.Ram) / float64(to.Ram)
+	}
+	return u
+}
+
+func addUsage(a, b data.Usage) data.Usage {
+	return data.Usage{CPU: a.CPU + b.CPU, Memory: a.Memory + b.Memory, DiskIO: a.DiskIO + b.DiskIO}
+}
+
+// busy returns how loaded a VM is, as the highest of its utilisation values
+// clamped to [0, 1]. VMs without metrics count as idle.
+func (p *planner) busy(vm *data.VMInstance) float64 {
+	u, ok := p.usage[vm.ID]
+	if !ok {
+		return 0
+	}
+	b := math.Max(u.CPU, math.Max(u.Memory, u.DiskIO))
+	return math.Min(math.Max(b, 0), 1)
+}
+
+// domainOf returns the key of the failure domain vm lives in.
+func (p *planner) domainOf(vm *data.VMInstance) string {
+	if p.domain == domainZone {
+		return vm.AvailabilityZone
+	}
+	return vm.Host
+}
+
+// antiAffinityGroups returns which of the server groups of vms keep their
+// members apart. A group whose policy cannot be read is assumed to.
+func antiAffinityGroups(vms []*data.VMInstance) map[string]bool {
+	groups := make(map[string]bool)
+	var token string
+	for _, vm := range vms {
+		for _, id := range vm.ServerGroups {
+			if _, ok := groups[id]; ok {
+				continue
+			}
+			if token == "" {
+				token = common.GetToken()
+			}
+			group, err := common.GetServerGroup(token, id)
+			if err != nil {
+				fmt.Printf("Error fetching server group %s, keeping its members apart: %s\n", id, err)
+				groups[id] = true
+				continue
+			}
+			groups[id] = group.Policy == "anti-affinity" || group.Policy == "soft-anti-affinity"
+		}
+	}
+	return groups
+}
+
+// sameFailureDomain reports whether a failure of source's domain could also
+// have taken out candidate. VMs on the same hypervisor always share a domain,
+// and VMs in a common anti-affinity server group are replicas that must stay
+// apart.
+func (p *planner) sameFailureDomain(source, candidate *data.VMInstance) bool {
+	if source.Host != "" && source.Host == candidate.Host {
+		return true
+	}
+	if p.domain == domainZone && source.AvailabilityZone != "" && source.AvailabilityZone == candidate.AvailabilityZone {
+		return true
+	}
+	for _, group := range source.ServerGroups {
+		if !p.antiAffinity[group] {
+			continue
+		}
+		for _, other := range candidate.ServerGroups {
+			if group == other {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rank scores every eligible candidate for source with the given strategy.
+// With a category, both sides are compared on that category's software only.
+func (p *planner) rank(strategy SimilarityStrategy, source *data.VMInstance, category string) []data.Candidate {
+	source = categoryView(source, category)
+
+	var eligible []*data.VMInstance
+	for _, vm := range p.vms {
+		if vm.ID == source.ID || p.failed[vm.ID] || p.sameFailureDomain(source, vm) || !p.hasCapacity(source, vm) {
+			continue
+		}
+		eligible = append(eligible, categoryView(vm, category))
+	}
+
+	candidates := rankCandidates(strategy, p.weights, source, eligible)
+	for i := range candidates {
+		vm := findVM(p.vms, candidates[i].ID)
+		candidates[i].Host = vm.Host
+		candidates[i].AvailabilityZone = vm.AvailabilityZone
+		candidates[i].Load = p.busy(vm)
+		candidates[i].Score = candidates[i].Similarity - float64(p.weights.Load)*candidates[i].Load
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+
+	return candidates
+}
+
+// choose picks the consolidation target for source, or nil when no eligible
+// candidate clears the threshold and the VM should be recreated instead.
+func (p *planner) choose(source *data.VMInstance, category string) (*data.VMInstance, float64) {
+	var viable []data.Candidate
+	for _, c := range p.rank(p.strategy(source), source, category) {
+		if c.Score >= float64(p.weights.Threshold) {
+			viable = append(viable, c)
+		}
+	}
+	if len(viable) == 0 {
+		return nil, 0
+	}
+
+	spread := func(c data.Candidate) float64 {
+		return c.Score - spreadPenalty*float64(p.placed[p.domainOf(findVM(p.vms, c.ID))])
+	}
+	sort.SliceStable(viable, func(i, j int) bool {
+		return spread(viable[i]) > spread(viable[j])
+	})
+
+	return findVM(p.vms, viable[0].ID), viable[0].Score
+}
+
+// place records that source was consolidated onto target, consuming the
+// target's volume slots and headroom for later decisions.
+func (p *planner) place(source, target *data.VMInstance) {
+	if domain := p.domainOf(target); domain != "" {
+		p.placed[domain]++
+	}
+	p.attached[target.ID] += len(nonOverlappingVolumes(source, target))
+	if _, ok := p.usage[source.ID]; ok {
+		p.usage[target.ID] = addUsage(p.usage[target.ID], p.movedUsage(source, target))
+	}
+}
+
+// placeRecreate reserves room for a new server of the given flavor on the
+// surviving hypervisor with the most free memory. It reports false when no
+// hypervisor can fit it; with unknown capacity it always succeeds.
+func (p *planner) placeRecreate(flavor data.FlavorDetail) (*data.Hypervisor, bool) {
+	if p.hypervisors == nil {
+		return nil, true
+	}
+
+	var best *data.Hypervisor
+	for _, h := range p.hypervisors {
+		if p.failedHosts[h.Service.Host] || h.State != "up" || h.Status != "enabled" {
+			continue
+		}
+		if h.Vcpus-h.VcpusUsed < flavor.Vcpus || h.MemoryMB-h.MemoryMBUsed < flavor.Ram {
+			continue
+		}
+		if best == nil || h.MemoryMB-h.MemoryMBUsed > best.MemoryMB-best.MemoryMBUsed {
+			best = h
+		}
+	}
+	if best == nil {
+		return nil, false
+	}
+
+	best.VcpusUsed += flavor.Vcpus
+	best.MemoryMBUsed += flavor.Ram
+	return best, true
+}
+
+// plan decides how to recover source. In single mode every volume goes to
+// one consolidation target or to a recreated VM. In hybrid mode each
+// category is placed on its own best target and only the categories without
+// one are recreated.
+func (p *planner) plan(source *data.VMInstance, mode string) data.RecoveryPlan {
+	now := time.Now().UTC()
+	plan := data.RecoveryPlan{
+		DecidedAt:   &now,
+		SourceID:    source.ID,
+		SourceName:  source.Name,
+		Mode:        mode,
+		Assignments: []data.Assignment{},
+	}
+
+	for _, group := range [][]data.Volume{source.Software.Languages, source.Software.Databases, source.Software.Webservers} {
+		for _, v := range group {
+			if v.Device == "" {
+				continue
+			}
+			if plan.Devices == nil {
+				plan.Devices = make(map[string]string)
+			}
+			plan.Devices[v.ID] = v.Device
+		}
+	}
+
+	var leftover []string
+
+	if mode == modeHybrid {
+		for _, category := range categories {
+			view := categoryView(source, category)
+			volumes := allVolumes(view)
+			if len(volumes) == 0 {
+				continue
+			}
+
+			target, score := p.choose(source, category)
+			if target == nil {
+				leftover = append(leftover, volumes...)
+				continue
+			}
+
+			plan.Assignments = append(plan.Assignments, newAssignment(view, target, category, score))
+			p.place(view, target)
+		}
+	} else {
+		target, score := p.choose(source, "")
+		if target == nil {
+			leftover = allVolumes(source)
+		} else {
+			for _, category := range categories {
+				view := categoryView(source, category)
+				if len(allVolumes(view)) == 0 {
+					continue
+				}
+				plan.Assignments = append(plan.Assignments, newAssignment(view, target, category, score))
+			}
+			p.place(source, target)
+		}
+	}
+
+	if len(leftover) > 0 {
+		plan.Recreate = &data.RecreateSpec{
+			Name:     source.Name + "-new",
+			OS:       source.OS,
+			FlavorID: source.FlavorID,
+			Volumes:  leftover,
+			Networks: source.Networks,
+
+			Attributes:   source.Attributes,
+			ServerGroups: source.ServerGroups,
+			RootVolume:   source.RootVolume,
+			Mounts:       mounts(source, leftover),
+		}
+	}
+
+	return plan
+}
+
+func newAssignment(source, target *data.VMInstance, category string, score float64) data.Assignment {
+	volumes := nonOverlappingVolumes(source, target)
+	if volumes == nil {
+		volumes = []string{}
+	}
+	return data.Assignment{
+		TargetID:   target.ID,
+		TargetName: target.Name,
+		TargetHost: target.Host,
+		Category:   category,
+		Score:      score,
+		Volumes:    volumes,
+		Mounts:     mounts(source, volumes),
+	}
+}
+
+// categoryView returns a copy of vm carrying only the software of one
+// category, or vm itself for an empty category.
+func categoryView(vm *data.VMInstance, category string) *data.VMInstance {
+	if category == "" {
+		return vm
+	}
+
+	view := *vm
+	view.Software = data.Software{}
+	switch category {
+	case categoryLanguage:
+		view.Software.Languages = vm.Software.Languages
+	case categoryDatabase:
+		view.Software.Databases = vm.Software.Databases
+	case categoryWebserver:
+		view.Software.Webservers = vm.Software.Webservers
+	}
+	return &view
+}
@@ -0,0 +1,210 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+func testPlanner(vms []*data.VMInstance) *planner {
+	return &planner{
+		weights:      data.Weight{Language: 1, Database: 1, Webserver: 1, Threshold: 0.5},
+		domain:       domainHost,
+		vms:          vms,
+		strategy:     func(*data.VMInstance) SimilarityStrategy { return jaccardStrategy{} },
+		placed:       make(map[string]int),
+		antiAffinity: make(map[string]bool),
+		usage:        make(map[string]data.Usage),
+		flavors:      make(map[string]data.FlavorDetail),
+		failed:       make(map[string]bool),
+		attached:     make(map[string]int),
+		maxVolumes:   defaultMaxVolumes,
+		failedHosts:  make(map[string]bool),
+	}
+}
+
+func TestChoose(t *testing.T) {
+	source := &data.VMInstance{ID: "src", Host: "h1", OS: "ubuntu",
+		Software: data.Software{Languages: []data.Volume{vol("s1", "python")}}}
+	// Targets share the source's volume, as consolidation matches volumes
+	// by ID; a partial target scores 0.5 and an exact one 1.
+	exact := func(id, host string) *data.VMInstance {
+		return &data.VMInstance{ID: id, Host: host, OS: "ubuntu",
+			Software: data.Software{Languages: []data.Volume{vol("s1", "python")}}}
+	}
+	partial := func(id, host string) *data.VMInstance {
+		return &data.VMInstance{ID: id, Host: host, OS: "ubuntu",
+			Software: data.Software{Languages: []data.Volume{vol("s1", "python")}, Webservers: []data.Volume{vol(id+"-2", "nginx")}}}
+	}
+
+	tests := []struct {
+		name      string
+		targets   []*data.VMInstance
+		placed    map[string]int
+		failed    []string
+		threshold float32
+		want      string
+	}{
+		{
+			name:    "best score outweighs a few placements",
+			targets: []*data.VMInstance{exact("a", "h2"), partial("b", "h3")},
+			placed:  map[string]int{"h2": 5},
+			want:    "a",
+		},
+		{
+			name:    "a busy domain spreads to the next target",
+			targets: []*data.VMInstance{exact("a", "h2"), partial("b", "h3")},
+			placed:  map[string]int{"h2": 11},
+			want:    "b",
+		},
+		{
+			name:      "spreading never picks a target below the threshold",
+			targets:   []*data.VMInstance{exact("a", "h2"), partial("b", "h3")},
+			placed:    map[string]int{"h2": 20},
+			threshold: 0.6,
+			want:      "a",
+		},
+		{
+			name:    "placements break a tie",
+			targets: []*data.VMInstance{exact("a", "h2"), exact("c", "h3")},
+			placed:  map[string]int{"h2": 5},
+			want:    "c",
+		},
+		{
+			name:    "same host is never chosen",
+			targets: []*data.VMInstance{exact("a", "h1"), partial("b", "h3")},
+			want:    "b",
+		},
+		{
+			name:    "failed VMs are never chosen",
+			targets: []*data.VMInstance{exact("a", "h2"), partial("b", "h3")},
+			failed:  []string{"a"},
+			want:    "b",
+		},
+		{
+			name:      "nothing clears the threshold",
+			targets:   []*data.VMInstance{partial("b", "h3")},
+			threshold: 0.9,
+			want:      "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPlanner(append([]*data.VMInstance{source}, tt.targets...))
+			p.fail(source)
+			for domain, n := range tt.placed {
+				p.placed[domain] = n
+			}
+			for _, id := range tt.failed {
+				p.failed[id] = true
+			}
+			if tt.threshold != 0 {
+				p.weights.Threshold = tt.threshold
+			}
+
+			target, _ := p.choose(source, "")
+			got := ""
+			if target != nil {
+				got = target.ID
+			}
+			if got != tt.want {
+				t.Errorf("choose() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSameFailureDomain(t *testing.T) {
+	tests := []struct {
+		name      string
+		domain    string
+		source    data.VMInstance
+		candidate data.VMInstance
+		want      bool
+	}{
+		{
+			name:      "same host",
+			domain:    domainHost,
+			source:    data.VMInstance{Host: "h1", AvailabilityZone: "az1"},
+			candidate: data.VMInstance{Host: "h1", AvailabilityZone: "az1"},
+			want:      true,
+		},
+		{
+			name:      "same zone with host domain",
+			domain:    domainHost,
+			source:    data.VMInstance{Host: "h1", AvailabilityZone: "az1"},
+			candidate: data.VMInstance{Host: "h2", AvailabilityZone: "az1"},
+			want:      false,
+		},
+		{
+			name:      "same zone with zone domain",
+			domain:    domainZone,
+			source:    data.VMInstance{Host: "h1", AvailabilityZone: "az1"},
+			candidate: data.VMInstance{Host: "h2", AvailabilityZone: "az1"},
+			want:      true,
+		},
+		{
+			name:      "common anti-affinity group",
+			domain:    domainHost,
+			source:    data.VMInstance{Host: "h1", ServerGroups: []string{"apart"}},
+			candidate: data.VMInstance{Host: "h2", ServerGroups: []string{"apart"}},
+			want:      true,
+		},
+		{
+			name:      "common affinity group",
+			domain:    domainHost,
+			source:    data.VMInstance{Host: "h1", ServerGroups: []string{"together"}},
+			candidate: data.VMInstance{Host: "h2", ServerGroups: []string{"together"}},
+			want:      false,
+		},
+		{
+			name:      "unknown host",
+			domain:    domainHost,
+			source:    data.VMInstance{},
+			candidate: data.VMInstance{},
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPlanner(nil)
+			p.domain = tt.domain
+			p.antiAffinity = map[string]bool{"apart": true, "together": false}
+
+			got := p.sameFailureDomain(&tt.source, &tt.candidate)
+			if got != tt.want {
+				t.Errorf("sameFailureDomain() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanAllReportsUnplaceableVMs(t *testing.T) {
+	first := &data.VMInstance{ID: "a", Name: "a", Host: "h1", OS: "ubuntu", FlavorID: "f",
+		Software: data.Software{Languages: []data.Volume{vol("a1", "python")}}}
+	second := &data.VMInstance{ID: "b", Name: "b", Host: "h1", OS: "ubuntu", FlavorID: "f",
+		Software: data.Software{Languages: []data.Volume{vol("b1", "python")}}}
+
+	p := testPlanner([]*data.VMInstance{first, second})
+	p.fail(first)
+	p.fail(second)
+	p.failedHosts["h1"] = true
+	p.flavors["f"] = data.FlavorDetail{ID: "f", Name: "small", Vcpus: 1, Ram: 1024}
+	p.hypervisors = []*data.Hypervisor{
+		{State: "up", Status: "enabled", Vcpus: 8, MemoryMB: 1536, Service: data.HypervisorService{Host: "h2"}},
+		{State: "up", Status: "enabled", Vcpus: 8, MemoryMB: 8192, Service: data.HypervisorService{Host: "h1"}},
+	}
+
+	plans, failures := p.planAll([]*data.VMInstance{first, second}, modeSingle)
+	if _, ok := plans["a"]; !ok || plans["a"].Recreate == nil {
+		t.Errorf("plans[a] = %+v, want a recreation", plans["a"])
+	}
+	if _, ok := plans["b"]; ok {
+		t.Errorf("plans[b] = %+v, want no plan", plans["b"])
+	}
+	if failures["b"] == nil || len(failures) != 1 {
+		t.Errorf("failures = %v, want only b", failures)
+	}
+}
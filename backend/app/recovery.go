@@ -0,0 +1,377 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/common"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+const volumeDetachTimeout = 2 * time.Minute
+
+// errUnknownMode is returned for a recovery mode the planner does not know.
+var errUnknownMode = errors.New("unknown recovery mode")
+
+// recoveryMode resolves the requested mode, falling back to the global
+// setting and then to single mode.
+func (a *AppHandler) recoveryMode(requested string) (string, error) {
+	mode := requested
+	if mode == "" {
+		setting, err := a.db.GetSetting(recoveryModeSetting)
+		if err != nil {
+			return "", err
+		}
+		mode = setting
+	}
+
+	switch mode {
+	case "":
+		return modeSingle, nil
+	case modeSingle, modeHybrid:
+		return mode, nil
+	}
+	return "", fmt.Errorf("%w: %s", errUnknownMode, mode)
+}
+
+// planRecovery builds the recovery plan for source against the current inventory.
+func (a *AppHandler) planRecovery(source *data.VMInstance, vms []*data.VMInstance, requestedMode string) (data.RecoveryPlan, error) {
+	mode, err := a.recoveryMode(requestedMode)
+	if err != nil {
+		return data.RecoveryPlan{}, err
+	}
+
+	p, err := a.newPlanner(vms)
+	if err != nil {
+		return data.RecoveryPlan{}, fmt.Errorf("error preparing recovery planner: %v", err)
+	}
+	p.fail(source)
+
+	return p.plan(source, mode), nil
+}
+
+// executePlan marks the failed VM, fences it, attaches every assigned volume
+// to its target, recreates a VM for whatever is left and records the result
+// in the inventory. Each call is a job step; if one fails, the volumes
+// already attached are detached again, a recreated server is deleted and
+// the VM gets its previous status back.
+func (a *AppHandler) executePlan(run *jobRun, plan data.RecoveryPlan, recoverReq data.RecoverRequest) error {
+	token := common.GetToken()
+
+	actions := []action{a.markFailedAction(plan), fenceAction(run, token, plan, recoverReq)}
+	actions = append(actions, a.planActions(run, plan)...)
+	actions = append(actions, a.placementAction(plan), a.inventoryAction(run, token, plan))
+
+	return run.execute(actions)
+}
+
+// placementAction counts the recovery against the failure domain of every
+// target, so later plans spread away from it. A hybrid plan can assign
+// several categories to one target, which is still a single recovery
+// directed at its domain. Undoing it takes the counts back.
+func (a *AppHandler) placementAction(plan data.RecoveryPlan) action {
+	var added []string
+	return action{
+		name: "record placements of " + plan.SourceName,
+		do: func() error {
+			counted := make(map[string]bool)
+			for _, assignment := range plan.Assignments {
+				if counted[assignment.TargetID] {
+					continue
+				}
+				counted[assignment.TargetID] = true
+				if domain := a.domainOfVM(assignment.TargetID); domain != "" {
+					err := a.db.AddPlacement(domain)
+					if err != nil {
+						return fmt.Errorf("error recording placement on %s: %v", domain, err)
+					}
+					added = append(added, domain)
+				}
+			}
+			return nil
+		},
+		undo: func() error {
+			for len(added) > 0 {
+				domain := added[len(added)-1]
+				err := a.db.RemovePlacement(domain)
+				if err != nil {
+					return fmt.Errorf("error removing placement on %s: %v", domain, err)
+				}
+				added = added[:len(added)-1]
+			}
+			return nil
+		},
+	}
+}
+
+// markFailedAction marks the source failed in the inventory so that no
+// other recovery picks it as a target. Undoing it restores the status it
+// had, so an aborted recovery leaves the VM usable as a candidate again.
+func (a *AppHandler) markFailedAction(plan data.RecoveryPlan) action {
+	previous := ""
+	marked := false
+	return action{
+		name: "mark " + plan.SourceName + " failed",
+		do: func() error {
+			source, err := a.db.GetVMInfo(plan.SourceID)
+			if err != nil {
+				return fmt.Errorf("error fetching %s: %v", plan.SourceName, err)
+			}
+			previous = source.Status
+
+			err = a.db.SetVMStatus(plan.SourceID, data.VMFailed)
+			if err != nil {
+				return fmt.Errorf("error marking %s failed: %v", plan.SourceName, err)
+			}
+			marked = true
+			return nil
+		},
+		undo: func() error {
+			if !marked {
+				return nil
+			}
+			return a.db.SetVMStatus(plan.SourceID, previous)
+		},
+	}
+}
+
+func (a *AppHandler) planActions(run *jobRun, plan data.RecoveryPlan) []action {
+	token := common.GetToken()
+	var actions []action
+
+	for _, assignment := range plan.Assignments {
+		assignment := assignment
+		for _, volumeID := range byDevice(assignment.Volumes, plan.Devices) {
+			volumeID := volumeID
+			attached := false
+			actions = append(actions, releaseAction(token, plan, volumeID))
+			actions = append(actions, action{
+				name: fmt.Sprintf("attach %s volume %s to %s", assignment.Category, volumeID, assignment.TargetName),
+				do: func() error {
+					run.addTarget(assignment.TargetID)
+					err := requireAvailable(token, volumeID)
+					if err != nil {
+						return err
+					}
+					err = attachOnDevice(run, token, assignment.TargetID, volumeID, plan.Devices[volumeID])
+					attached = err == nil
+					return err
+				},
+				undo: func() error {
+					if !attached {
+						return nil
+					}
+					return common.DetachVolume(token, assignment.TargetID, volumeID)
+				},
+				preview: func() []data.APICall {
+					return []data.APICall{attachCall(assignment.TargetID, volumeID, plan.Devices[volumeID])}
+				},
+			})
+		}
+	}
+
+	if plan.Recreate != nil {
+		actions = append(actions, a.recreateActions(run, token, plan)...)
+	}
+
+	return actions
+}
+
+// releaseAction detaches a volume from the failed source server so it can
+// be attached elsewhere; undoing it attaches the volume back to the source.
+func releaseAction(token string, plan data.RecoveryPlan, volumeID string) action {
+	detached := false
+	return action{
+		name: fmt.Sprintf("detach volume %s from %s", volumeID, plan.SourceName),
+		do: func() error {
+			var err error
+			detached, err = releaseVolume(token, plan.SourceID, volumeID)
+			return err
+		},
+		undo: func() error {
+			if !detached {
+				return nil
+			}
+			return common.AttachVolume(token, plan.SourceID, volumeID)
+		},
+		preview: func() []data.APICall {
+			volume, err := common.GetVolume(token, volumeID)
+			if err == nil && volume.Status == "available" {
+				return nil
+			}
+			return []data.APICall{{
+				Method: "DELETE",
+				URL:    common.BaseOpenstackUrl + "/compute/v2.1/servers/" + plan.SourceID + "/os-volume_attachments/" + volumeID,
+				Note:   "falls back to deleting the Cinder attachment if the detach does not complete",
+			}}
+		},
+	}
+}
+
+// releaseVolume detaches volumeID from sourceID and waits until Cinder
+// reports it available. Nova is asked first; when it fails or the detach
+// never completes because the source compute host is down, the attachment
+// is deleted in Cinder, falling back to os-force_detach. It reports whether
+// the volume had to be detached at all.
+func releaseVolume(token string, sourceID string, volumeID string) (bool, error) {
+	volume, err := common.GetVolume(token, volumeID)
+	if err != nil {
+		return false, fmt.Errorf("error fetching volume %s: %v", volumeID, err)
+	}
+	if volume.Status == "available" {
+		return false, nil
+	}
+
+	err = common.DetachVolume(token, sourceID, volumeID)
+	if err == nil {
+		err = common.WaitForVolumeStatus(token, volumeID, "available", volumeDetachTimeout)
+		if err == nil {
+			return true, nil
+		}
+	}
+	fmt.Printf("Error detaching volume %s through Nova, detaching in Cinder: %s\n", volumeID, err)
+
+	volume, err = common.GetVolume(token, volumeID)
+	if err != nil {
+		return false, fmt.Errorf("error fetching volume %s: %v", volumeID, err)
+	}
+	for _, attachment := range volume.Attachments {
+		if attachment.ServerID != sourceID {
+			continue
+		}
+		err := common.DeleteVolumeAttachment(token, attachment.AttachmentID)
+		if err != nil {
+			err = common.ForceDetachVolume(token, volumeID, attachment.AttachmentID)
+			if err != nil {
+				return false, fmt.Errorf("error force detaching volume %s: %v", volumeID, err)
+			}
+		}
+	}
+
+	err = common.WaitForVolumeStatus(token, volumeID, "available", volumeDetachTimeout)
+	if err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// attachOnDevice attaches volumeID to serverID, asking for the device it had
+// on the failed VM when the server has that device free, and records where
+// the volume ended up so a remapping can be acted on.
+func attachOnDevice(run *jobRun, token string, serverID string, volumeID string, original string) error {
+	device := original
+	if device != "" {
+		attachments, err := common.GetVolumeAttachments(token, serverID)
+		if err != nil {
+			return fmt.Errorf("error fetching volume attachments of %s: %v", serverID, err)
+		}
+		for _, attachment := range attachments {
+			if attachment.Device == device {
+				device = ""
+				break
+			}
+		}
+	}
+
+	attached, err := common.AttachVolumeAt(token, serverID, volumeID, device)
+	if err != nil && device != "" {
+		// Some hypervisors refuse requested device names; let Nova pick.
+		attached, err = common.AttachVolumeAt(token, serverID, volumeID, "")
+	}
+	if err != nil {
+		return err
+	}
+
+	run.addDevice(data.DeviceMapping{
+		VolumeID: volumeID,
+		ServerID: serverID,
+		Original: original,
+		Device:   attached,
+		Remapped: original != "" && attached != original,
+	})
+	if original != "" && attached != original {
+		fmt.Printf("Volume %s attached to %s as %s instead of %s\n", volumeID, serverID, attached, original)
+	}
+	return nil
+}
+
+// byDevice orders volume IDs by the device they had, so that volumes
+// attached one after another keep their original order. Volumes without a
+// known device come last.
+func byDevice(volumeIDs []string, devices map[string]string) []string {
+	ordered := append([]string(nil), volumeIDs...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := devices[ordered[i]], devices[ordered[j]]
+		if a == "" || b == "" {
+			return b == "" && a != ""
+		}
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+	return ordered
+}
+
+// requireAvailable fails unless the volume can be attached to another
+// server, which Cinder only allows for available or multi-attach volumes.
+func requireAvailable(token string, volumeID string) error {
+	volume, err := common.GetVolume(token, volumeID)
+	if err != nil {
+		return fmt.Errorf("error fetching volume %s: %v", volumeID, err)
+	}
+	if volume.Status != "available" && !volume.Multiattach {
+		return fmt.Errorf("volume %s is %s, not available", volumeID, volume.Status)
+	}
+	return nil
+}
+
+// domainOfVM returns the failure domain key of a VM in the inventory.
+func (a *AppHandler) domainOfVM(id string) string {
+	vm, err := a.db.GetVMInfo(id)
+	if err != nil {
+		return ""
+	}
+	domain, _ := a.db.GetSetting(failureDomainSetting)
+	if domain == domainZone {
+		return vm.AvailabilityZone
+	}
+	return vm.Host
+}
+
+func (a *AppHandler) getRecoveryMode(w http.ResponseWriter, r *http.Request) {
+	mode, err := a.recoveryMode("")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting recovery mode: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, data.RecoverRequest{Mode: mode})
+}
+
+func (a *AppHandler) setRecoveryMode(w http.ResponseWriter, r *http.Request) {
+	var modeReq data.RecoverRequest
+
+	err := json.NewDecoder(r.Body).Decode(&modeReq)
+	if err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	if modeReq.Mode != modeSingle && modeReq.Mode != modeHybrid {
+		http.Error(w, fmt.Sprintf("recovery mode must be %q or %q", modeSingle, modeHybrid), http.StatusBadRequest)
+		return
+	}
+
+	err = a.db.SetSetting(recoveryModeSetting, modeReq.Mode)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error setting recovery mode: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, modeReq)
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestByDevice(t *testing.T) {
+	tests := []struct {
+		name    string
+		volumes []string
+		devices map[string]string
+		want    []string
+	}{
+		{
+			name:    "no devices keeps the order",
+			volumes: []string{"a", "b", "c"},
+			want:    []string{"a", "b", "c"},
+		},
+		{
+			name:    "ordered by device",
+			volumes: []string{"a", "b", "c"},
+			devices: map[string]string{"a": "/dev/vdd", "b": "/dev/vdb", "c": "/dev/vdc"},
+			want:    []string{"b", "c", "a"},
+		},
+		{
+			name:    "vdaa comes after vdz",
+			volumes: []string{"a", "b"},
+			devices: map[string]string{"a": "/dev/vdaa", "b": "/dev/vdz"},
+			want:    []string{"b", "a"},
+		},
+		{
+			name:    "unknown devices last",
+			volumes: []string{"a", "b", "c", "d"},
+			devices: map[string]string{"b": "/dev/vdc", "d": "/dev/vdb"},
+			want:    []string{"d", "b", "a", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := byDevice(tt.volumes, tt.devices)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("byDevice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
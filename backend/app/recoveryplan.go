@@ -0,0 +1,463 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/common"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/model"
+)
+
+const (
+	defaultWaitTimeout = 5 * time.Minute
+	probeInterval      = 5 * time.Second
+)
+
+func (a *AppHandler) getPlans(w http.ResponseWriter, r *http.Request) {
+	plans, err := a.db.GetPlanDefinitions()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting recovery plans: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, plans)
+}
+
+func (a *AppHandler) getPlan(w http.ResponseWriter, r *http.Request) {
+	plan, ok := a.loadPlan(w, mux.Vars(r)["name"])
+	if !ok {
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, plan)
+}
+
+func (a *AppHandler) setPlan(w http.ResponseWriter, r *http.Request) {
+	var plan data.PlanDefinition
+
+	err := json.NewDecoder(r.Body).Decode(&plan)
+	if err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	plan.Name = mux.Vars(r)["name"]
+	if plan.Policy == "" {
+		plan.Policy = data.PlanPolicyStop
+	}
+
+	_, err = planLevels(plan)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = a.db.SetPlanDefinition(plan)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error setting recovery plan: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, plan)
+}
+
+func (a *AppHandler) deletePlan(w http.ResponseWriter, r *http.Request) {
+	err := a.db.DeletePlanDefinition(mux.Vars(r)["name"])
+	if errors.Is(err, model.ErrPlanNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("error deleting recovery plan: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AppHandler) loadPlan(w http.ResponseWriter, name string) (*data.PlanDefinition, bool) {
+	plan, err := a.db.GetPlanDefinition(name)
+	if errors.Is(err, model.ErrPlanNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("error getting recovery plan: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+	return plan, true
+}
+
+// runPlan plans the recovery of every VM of a named plan against one shared
+// planner, in dependency order so earlier VMs get the first pick of
+// targets, and queues a plan job that executes them level by level. A VM
+// that cannot be placed fails on its own and only its dependents are skipped.
+func (a *AppHandler) runPlan(w http.ResponseWriter, r *http.Request) {
+	var recoverReq data.RecoverRequest
+
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&recoverReq)
+		if err != nil {
+			http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if o := recoverReq.FenceOverride; o != nil && (o.Operator == "" || o.Reason == "") {
+		http.Error(w, "fence_override requires an operator and a reason", http.StatusBadRequest)
+		return
+	}
+
+	definition, ok := a.loadPlan(w, mux.Vars(r)["name"])
+	if !ok {
+		return
+	}
+
+	levels, err := planLevels(*definition)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	mode, err := a.recoveryMode(recoverReq.Mode)
+	if errors.Is(err, errUnknownMode) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("error getting recovery mode: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	allVMs, err := a.db.GetVMsInfo()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching all VMs: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var failedVMs []*data.VMInstance
+	for _, level := range levels {
+		for _, member := range level {
+			vm := findVM(allVMs, member.VMID)
+			if vm == nil {
+				http.Error(w, fmt.Sprintf("VM %s of plan %s is not in the inventory", member.VMID, definition.Name), http.StatusUnprocessableEntity)
+				return
+			}
+			failedVMs = append(failedVMs, vm)
+		}
+	}
+
+	p, err := a.newFailurePlanner(allVMs, failedVMs, nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error preparing recovery planner: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	parent, err := newJob(data.JobKindPlan, "", data.PlanRun{Plan: *definition, RecoverRequest: recoverReq})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// A VM that cannot be placed gets a failed child job and its dependents
+	// skipped ones; they are not planned, so they reserve no capacity.
+	planned := make(map[string]data.RecoveryPlan)
+	failures := make(map[string]error)
+	skipped := make(map[string]string)
+	for _, level := range levels {
+		var levelVMs []*data.VMInstance
+		for _, member := range level {
+			for _, dependency := range member.DependsOn {
+				_, failed := failures[dependency]
+				if _, skip := skipped[dependency]; failed || skip {
+					skipped[member.VMID] = fmt.Sprintf("dependency %s could not be planned", dependency)
+					break
+				}
+			}
+			if _, skip := skipped[member.VMID]; !skip {
+				levelVMs = append(levelVMs, findVM(allVMs, member.VMID))
+			}
+		}
+
+		levelPlans, levelFailures := p.planAll(levelVMs, mode)
+		for id, plan := range levelPlans {
+			planned[id] = plan
+		}
+		for id, err := range levelFailures {
+			failures[id] = err
+		}
+	}
+	if len(planned) == 0 {
+		http.Error(w, joinFailures(failedVMs, failures), http.StatusConflict)
+		return
+	}
+
+	var children []*data.Job
+	var plans []data.RecoveryPlan
+	for _, vm := range failedVMs {
+		child, err := newJob(data.JobKindRecover, vm.ID, recoverReq)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		child.ParentID = parent.ID
+		if plan, ok := planned[vm.ID]; ok {
+			child.Plan = &plan
+			plans = append(plans, plan)
+		} else if reason, ok := skipped[vm.ID]; ok {
+			now := time.Now().UTC()
+			child.Status = data.JobSkipped
+			child.Error = reason
+			child.FinishedAt = &now
+		} else {
+			failUnplanned(child, failures[vm.ID])
+		}
+
+		children = append(children, child)
+	}
+
+	err = a.holdForApproval(parent, failedVMs, recoverReq.Operator)
+	if errors.Is(err, errOperatorRequired) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Error checking approval policy: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	err = a.submitWithChildren(parent, children, plans)
+	if err != nil {
+		if !writeLockConflict(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to queue recovery: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	acceptJob(w, parent)
+}
+
+// runPlanJob recovers the VMs of a plan one dependency level at a time,
+// running each level in parallel. A VM whose dependencies did not all
+// recover is skipped; with the stop policy the first failed recovery skips
+// every VM that has not started yet.
+func (a *AppHandler) runPlanJob(run *jobRun) error {
+	var planRun data.PlanRun
+	err := json.Unmarshal(run.job.Request, &planRun)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling plan run: %v", err)
+	}
+	definition := planRun.Plan
+
+	levels, err := planLevels(definition)
+	if err != nil {
+		return err
+	}
+
+	children, err := a.db.GetChildJobs(run.job.ID)
+	if err != nil {
+		return fmt.Errorf("error loading child jobs: %v", err)
+	}
+	jobs := make(map[string]*data.Job)
+	for _, child := range children {
+		jobs[child.VMID] = child
+	}
+
+	parallelism := boundedParallelism(definition.Parallelism)
+	failed := make(map[string]bool)
+	waits := make(map[string]*data.WaitCondition)
+	// stopped is set once a recovery that ran has failed. A VM that could
+	// not be planned never started anything, so like with the continue
+	// policy it only holds back its dependents.
+	stopped := false
+
+	for _, level := range levels {
+		var ready []*data.Job
+		for _, member := range level {
+			child, ok := jobs[member.VMID]
+			if !ok {
+				return fmt.Errorf("plan job has no recovery job for VM %s", member.VMID)
+			}
+			waits[child.ID] = member.Wait
+
+			if child.Status == data.JobFailed || child.Status == data.JobSkipped {
+				failed[member.VMID] = true
+				continue
+			}
+
+			reason := ""
+			if stopped && definition.Policy != data.PlanPolicyContinue {
+				reason = "an earlier recovery in the plan failed"
+			}
+			for _, dependency := range member.DependsOn {
+				if failed[dependency] {
+					reason = fmt.Sprintf("dependency %s was not recovered", dependency)
+					break
+				}
+			}
+			if reason != "" {
+				failed[member.VMID] = true
+				a.skipJob(child, reason)
+				continue
+			}
+			ready = append(ready, child)
+		}
+
+		levelFailed := a.runChildren(run, ready, parallelism, func(child *data.Job) error {
+			return a.waitForRecovery(child, waits[child.ID])
+		})
+		for _, child := range ready {
+			if levelFailed[child.ID] {
+				failed[child.VMID] = true
+				stopped = true
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d VMs in plan %s were not recovered", len(failed), len(children), definition.Name)
+	}
+	return nil
+}
+
+func (a *AppHandler) skipJob(job *data.Job, reason string) {
+	now := time.Now().UTC()
+	job.Status = data.JobSkipped
+	job.Error = reason
+	job.FinishedAt = &now
+	if err := a.db.UpdateJob(*job); err != nil {
+		fmt.Printf("Error marking job %s skipped: %s\n", job.ID, err)
+	}
+}
+
+// waitForRecovery holds back the dependents of a recovered VM until its
+// wait condition is met on every server that now hosts its workloads.
+func (a *AppHandler) waitForRecovery(child *data.Job, wait *data.WaitCondition) error {
+	if wait == nil {
+		return nil
+	}
+
+	if wait.DelaySeconds > 0 {
+		time.Sleep(time.Duration(wait.DelaySeconds) * time.Second)
+	}
+	if wait.Port == 0 {
+		return nil
+	}
+
+	timeout := defaultWaitTimeout
+	if wait.TimeoutSeconds > 0 {
+		timeout = time.Duration(wait.TimeoutSeconds) * time.Second
+	}
+
+	job, err := a.db.GetJob(child.ID)
+	if err != nil {
+		return err
+	}
+
+	token := common.GetToken()
+	for _, target := range job.Targets {
+		server, err := common.GetServer(token, target)
+		if err != nil {
+			return fmt.Errorf("error fetching server %s: %v", target, err)
+		}
+		ip := common.FixedIP(server)
+		if ip == "" {
+			return fmt.Errorf("server %s has no fixed IP to probe", server.Name)
+		}
+		err = waitForTCP(net.JoinHostPort(ip, strconv.Itoa(wait.Port)), timeout)
+		if err != nil {
+			return fmt.Errorf("%s never became ready: %v", server.Name, err)
+		}
+	}
+	return nil
+}
+
+// waitForTCP polls address until it accepts a TCP connection.
+func waitForTCP(address string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", address, probeInterval)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s: %v", address, err)
+		}
+		time.Sleep(probeInterval)
+	}
+}
+
+// planLevels validates a plan and groups its VMs into dependency levels:
+// every VM comes after all of its dependencies, and each level is ordered
+// by priority, highest first.
+func planLevels(plan data.PlanDefinition) ([][]data.PlanVM, error) {
+	if plan.Name == "" {
+		return nil, fmt.Errorf("recovery plan requires a name")
+	}
+	if len(plan.VMs) == 0 {
+		return nil, fmt.Errorf("recovery plan %s has no VMs", plan.Name)
+	}
+	if plan.Policy != "" && plan.Policy != data.PlanPolicyStop && plan.Policy != data.PlanPolicyContinue {
+		return nil, fmt.Errorf("policy must be %q or %q", data.PlanPolicyStop, data.PlanPolicyContinue)
+	}
+
+	members := make(map[string]data.PlanVM)
+	for _, member := range plan.VMs {
+		if member.VMID == "" {
+			return nil, fmt.Errorf("every VM of a recovery plan requires a vm_id")
+		}
+		if _, ok := members[member.VMID]; ok {
+			return nil, fmt.Errorf("VM %s appears twice in recovery plan %s", member.VMID, plan.Name)
+		}
+		members[member.VMID] = member
+	}
+
+	pending := make(map[string]int)
+	dependents := make(map[string][]string)
+	for _, member := range plan.VMs {
+		for _, dependency := range member.DependsOn {
+			if _, ok := members[dependency]; !ok {
+				return nil, fmt.Errorf("VM %s depends on %s, which is not in the plan", member.VMID, dependency)
+			}
+			pending[member.VMID]++
+			dependents[dependency] = append(dependents[dependency], member.VMID)
+		}
+	}
+
+	var levels [][]data.PlanVM
+	var level []data.PlanVM
+	for _, member := range plan.VMs {
+		if pending[member.VMID] == 0 {
+			level = append(level, member)
+		}
+	}
+
+	placed := 0
+	for len(level) > 0 {
+		sort.SliceStable(level, func(i, j int) bool {
+			return level[i].Priority > level[j].Priority
+		})
+		levels = append(levels, level)
+		placed += len(level)
+
+		var next []data.PlanVM
+		for _, member := range level {
+			for _, id := range dependents[member.VMID] {
+				pending[id]--
+				if pending[id] == 0 {
+					next = append(next, members[id])
+				}
+			}
+		}
+		level = next
+	}
+
+	if placed != len(plan.VMs) {
+		return nil, fmt.Errorf("recovery plan %s has a dependency cycle", plan.Name)
+	}
+	return levels, nil
+}
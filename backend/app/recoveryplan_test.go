@@ -0,0 +1,102 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+func TestPlanLevels(t *testing.T) {
+	tests := []struct {
+		name    string
+		plan    data.PlanDefinition
+		want    [][]string
+		wantErr bool
+	}{
+		{
+			name: "dependencies first, then by priority",
+			plan: data.PlanDefinition{Name: "web", VMs: []data.PlanVM{
+				{VMID: "db"},
+				{VMID: "app", DependsOn: []string{"db"}},
+				{VMID: "cache", Priority: 10, DependsOn: []string{"db"}},
+				{VMID: "dns", Priority: 5},
+			}},
+			want: [][]string{{"dns", "db"}, {"cache", "app"}},
+		},
+		{
+			name: "chain",
+			plan: data.PlanDefinition{Name: "chain", Policy: data.PlanPolicyStop, VMs: []data.PlanVM{
+				{VMID: "c", DependsOn: []string{"b"}},
+				{VMID: "b", DependsOn: []string{"a"}},
+				{VMID: "a"},
+			}},
+			want: [][]string{{"a"}, {"b"}, {"c"}},
+		},
+		{
+			name:    "no name",
+			plan:    data.PlanDefinition{VMs: []data.PlanVM{{VMID: "a"}}},
+			wantErr: true,
+		},
+		{
+			name:    "no VMs",
+			plan:    data.PlanDefinition{Name: "empty"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown policy",
+			plan:    data.PlanDefinition{Name: "p", Policy: "retry", VMs: []data.PlanVM{{VMID: "a"}}},
+			wantErr: true,
+		},
+		{
+			name:    "missing vm_id",
+			plan:    data.PlanDefinition{Name: "p", VMs: []data.PlanVM{{}}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate VM",
+			plan:    data.PlanDefinition{Name: "p", VMs: []data.PlanVM{{VMID: "a"}, {VMID: "a"}}},
+			wantErr: true,
+		},
+		{
+			name:    "dependency outside the plan",
+			plan:    data.PlanDefinition{Name: "p", VMs: []data.PlanVM{{VMID: "a", DependsOn: []string{"x"}}}},
+			wantErr: true,
+		},
+		{
+			name: "cycle",
+			plan: data.PlanDefinition{Name: "p", VMs: []data.PlanVM{
+				{VMID: "a", DependsOn: []string{"b"}},
+				{VMID: "b", DependsOn: []string{"a"}},
+			}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			levels, err := planLevels(tt.plan)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("planLevels() returned no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("planLevels() error = %v", err)
+			}
+
+			var got [][]string
+			for _, level := range levels {
+				var ids []string
+				for _, member := range level {
+					ids = append(ids, member.VMID)
+				}
+				got = append(got, ids)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planLevels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
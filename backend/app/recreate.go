@@ -0,0 +1,345 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/common"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+const recreateTimeout = 10 * time.Minute
+
+const portDetachTimeout = 2 * time.Minute
+
+const (
+	portReuse   = "port"
+	portFixedIP = "fixed_ip"
+)
+
+// recreateActions boot a replacement for the failed VM with the volumes no
+// survivor took over. The replacement joins the networks of the failed VM,
+// on the same ports when they can be released from it, or else on the same
+// fixed IPs when the old ports are gone, and takes over its floating IPs.
+// A boot-from-volume VM is replaced by booting its root volume.
+func (a *AppHandler) recreateActions(run *jobRun, token string, plan data.RecoveryPlan) []action {
+	spec := plan.Recreate
+	var actions []action
+
+	for _, volumeID := range spec.Volumes {
+		actions = append(actions, releaseAction(token, plan, volumeID))
+	}
+
+	// root is the volume the replacement boots from, the original root
+	// volume or a clone of it.
+	var root string
+	if spec.RootVolume != "" {
+		actions = append(actions, releaseRootAction(token, plan, &root))
+	}
+
+	// ports records how each original port can be reused, filled in as
+	// the release actions run.
+	ports := make(map[string]string)
+	for _, network := range spec.Networks {
+		actions = append(actions, releasePortAction(token, plan, network, ports))
+	}
+
+	// serverID is the replacement, set once it has been created.
+	var serverID string
+	actions = append(actions, action{
+		name: "recreate " + spec.Name,
+		do: func() error {
+			req, err := a.serverCreate(spec, ports, root)
+			if err != nil {
+				return err
+			}
+			serverID, err = common.CreateServer(token, req)
+			if err != nil {
+				return fmt.Errorf("error creating server: %v", err)
+			}
+			run.addTarget(serverID)
+			run.setRecreated(serverID)
+
+			_, err = waitForActive(token, serverID, recreateTimeout)
+			return err
+		},
+		undo: func() error {
+			if serverID == "" {
+				return nil
+			}
+			return common.DeleteServer(token, serverID)
+		},
+		preview: func() []data.APICall {
+			req, err := a.serverCreate(spec, ports, root)
+			if err != nil {
+				return []data.APICall{{Method: "POST", URL: common.BaseOpenstackUrl + "/compute/v2.1/servers", Note: err.Error()}}
+			}
+			return []data.APICall{{
+				Method: "POST",
+				URL:    common.BaseOpenstackUrl + "/compute/v2.1/servers",
+				Body:   req,
+			}}
+		},
+	})
+
+	for _, volumeID := range byDevice(spec.Volumes, plan.Devices) {
+		volumeID := volumeID
+		attached := false
+		actions = append(actions, action{
+			name: fmt.Sprintf("attach volume %s to %s", volumeID, spec.Name),
+			do: func() error {
+				err := attachOnDevice(run, token, serverID, volumeID, plan.Devices[volumeID])
+				attached = err == nil
+				return err
+			},
+			undo: func() error {
+				if !attached {
+					return nil
+				}
+				return common.DetachVolume(token, serverID, volumeID)
+			},
+			preview: func() []data.APICall {
+				return []data.APICall{attachCall("{new server}", volumeID, plan.Devices[volumeID])}
+			},
+		})
+	}
+
+	for _, network := range spec.Networks {
+		for _, fip := range network.FloatingIPs {
+			actions = append(actions, moveFloatingIPAction(token, spec.Name, &serverID, network, fip, ports))
+		}
+	}
+
+	return actions
+}
+
+// serverCreate builds the create request for the replacement VM, with the
+// access settings and server group of the failed VM. It boots from root
+// when one is given, and from the image of the OS otherwise.
+func (a *AppHandler) serverCreate(spec *data.RecreateSpec, ports map[string]string, root string) (data.CreateServerRequest, error) {
+	server := data.ServerCreate{
+		Name:      spec.Name,
+		FlavorRef: spec.FlavorID,
+		Networks:  "auto",
+		KeyName:   spec.Attributes.KeyName,
+		Metadata:  spec.Attributes.Metadata,
+		Tags:      spec.Attributes.Tags,
+		UserData:  userData(mountScript(spec.Mounts)),
+	}
+
+	if root != "" {
+		server.BlockDeviceMapping = []data.BlockDevice{{
+			BootIndex:       0,
+			UUID:            root,
+			SourceType:      "volume",
+			DestinationType: "volume",
+		}}
+	} else {
+		imageID, err := a.db.GetImageID(spec.OS)
+		if err != nil {
+			return data.CreateServerRequest{}, err
+		}
+		server.ImageRef = imageID
+	}
+	for _, name := range spec.Attributes.SecurityGroups {
+		server.SecurityGroups = append(server.SecurityGroups, data.SecurityGroupName{Name: name})
+	}
+
+	if len(spec.Networks) > 0 {
+		var networks []data.ServerNetwork
+		for _, network := range spec.Networks {
+			switch {
+			case ports[network.PortID] == portReuse:
+				networks = append(networks, data.ServerNetwork{Port: network.PortID})
+			case ports[network.PortID] == portFixedIP && len(network.FixedIPs) > 0:
+				networks = append(networks, data.ServerNetwork{UUID: network.NetworkID, FixedIP: network.FixedIPs[0]})
+			default:
+				networks = append(networks, data.ServerNetwork{UUID: network.NetworkID})
+			}
+		}
+		server.Networks = networks
+	}
+
+	req := data.CreateServerRequest{Server: server}
+	if len(spec.ServerGroups) > 0 {
+		req.SchedulerHints = &data.SchedulerHints{Group: spec.ServerGroups[0]}
+	}
+	return req, nil
+}
+
+// releaseRootAction frees the root volume of the failed VM for the
+// replacement to boot from. When it cannot be detached from the failed VM,
+// the replacement boots from a clone of it instead. Undoing it deletes the
+// clone. A released root volume cannot be given back as the failed VM's
+// boot disk, so undoing that fails and leaves the rollback incomplete for
+// an operator to repair.
+func releaseRootAction(token string, plan data.RecoveryPlan, root *string) action {
+	rootID := plan.Recreate.RootVolume
+	released := false
+	cloned := false
+	return action{
+		name: fmt.Sprintf("release root volume %s from %s", rootID, plan.SourceName),
+		do: func() error {
+			detached, err := releaseVolume(token, plan.SourceID, rootID)
+			released = detached
+			if err == nil {
+				*root = rootID
+				return nil
+			}
+			fmt.Printf("Error releasing root volume %s, booting from a clone: %s\n", rootID, err)
+
+			cloneID, err := common.CloneVolume(token, rootID, plan.Recreate.Name+"-root")
+			if err != nil {
+				return fmt.Errorf("error cloning root volume %s: %v", rootID, err)
+			}
+			*root = cloneID
+			cloned = true
+			return common.WaitForVolumeStatus(token, cloneID, "available", recreateTimeout)
+		},
+		undo: func() error {
+			if cloned {
+				err := common.DeleteVolume(token, *root)
+				if err != nil {
+					return err
+				}
+			}
+			if released {
+				return fmt.Errorf("root volume %s was detached from %s and must be reattached as its boot disk by an operator",
+					rootID, plan.SourceName)
+			}
+			return nil
+		},
+		preview: func() []data.APICall {
+			*root = rootID
+			volume, err := common.GetVolume(token, rootID)
+			if err == nil && volume.Status == "available" {
+				return nil
+			}
+			return []data.APICall{{
+				Method: "DELETE",
+				URL:    common.BaseOpenstackUrl + "/compute/v2.1/servers/" + plan.SourceID + "/os-volume_attachments/" + rootID,
+				Note:   "the replacement boots from a clone of the root volume if it cannot be detached",
+			}}
+		},
+	}
+}
+
+// releasePortAction detaches an original port from the failed VM through
+// Nova so the replacement can use it, keeping its MAC address and fixed
+// IPs. A port that no longer exists leaves its fixed IP free instead; a
+// port that cannot be detached makes the replacement take a new address on
+// the same network. Undoing it attaches the port back to the failed VM.
+func releasePortAction(token string, plan data.RecoveryPlan, network data.NetworkInterface, ports map[string]string) action {
+	detached := false
+	return action{
+		name: fmt.Sprintf("release port %s from %s", network.PortID, plan.SourceName),
+		do: func() error {
+			port, err := common.GetPort(token, network.PortID)
+			if err == common.ErrNotFound {
+				ports[network.PortID] = portFixedIP
+				return nil
+			}
+			if err != nil {
+				fmt.Printf("Error fetching port %s, the replacement gets a new address: %s\n", network.PortID, err)
+				return nil
+			}
+
+			if port.DeviceID != "" && port.DeviceID != plan.SourceID {
+				fmt.Printf("Port %s is used by %s, the replacement gets a new address\n", network.PortID, port.DeviceID)
+				return nil
+			}
+			if port.DeviceID != "" {
+				err = common.DetachInterface(token, plan.SourceID, network.PortID)
+				if err != nil {
+					fmt.Printf("Error detaching port %s, the replacement gets a new address: %s\n", network.PortID, err)
+					return nil
+				}
+				detached = true
+
+				err = common.WaitForPortFree(token, network.PortID, portDetachTimeout)
+				if err != nil {
+					fmt.Printf("Error detaching port %s, the replacement gets a new address: %s\n", network.PortID, err)
+					return nil
+				}
+			}
+			ports[network.PortID] = portReuse
+			return nil
+		},
+		undo: func() error {
+			if !detached {
+				return nil
+			}
+			return common.AttachInterface(token, plan.SourceID, network.PortID)
+		},
+		preview: func() []data.APICall {
+			port, err := common.GetPort(token, network.PortID)
+			if err == common.ErrNotFound {
+				ports[network.PortID] = portFixedIP
+				return nil
+			}
+			ports[network.PortID] = portReuse
+			if err == nil && port.DeviceID == "" {
+				return nil
+			}
+			return []data.APICall{{
+				Method: "DELETE",
+				URL:    common.BaseOpenstackUrl + "/compute/v2.1/servers/" + plan.SourceID + "/os-interface/" + network.PortID,
+				Note:   "then waits for Neutron to report the port unbound",
+			}}
+		},
+	}
+}
+
+// moveFloatingIPAction associates a floating IP of an original port that was
+// not reused with the replacement's port on the same network. Undoing it
+// moves the floating IP back to the original port.
+func moveFloatingIPAction(token string, name string, serverID *string, network data.NetworkInterface, fip data.FloatingIP,
+	ports map[string]string) action {
+	moved := false
+	return action{
+		name: fmt.Sprintf("move floating IP %s to %s", fip.Address, name),
+		do: func() error {
+			if ports[network.PortID] == portReuse {
+				return nil
+			}
+
+			interfaces, err := common.GetServerInterfaces(token, *serverID)
+			if err != nil {
+				return fmt.Errorf("error fetching interfaces of the replacement: %v", err)
+			}
+			portID := ""
+			for _, iface := range interfaces {
+				if iface.NetID == network.NetworkID {
+					portID = iface.PortID
+					break
+				}
+			}
+			if portID == "" {
+				return fmt.Errorf("replacement has no port on network %s", network.NetworkID)
+			}
+
+			err = common.AssociateFloatingIP(token, fip.ID, portID)
+			if err != nil {
+				return fmt.Errorf("error moving floating IP %s: %v", fip.Address, err)
+			}
+			moved = true
+			return nil
+		},
+		undo: func() error {
+			if !moved {
+				return nil
+			}
+			return common.AssociateFloatingIP(token, fip.ID, network.PortID)
+		},
+		preview: func() []data.APICall {
+			if ports[network.PortID] == portReuse {
+				return nil
+			}
+			return []data.APICall{{
+				Method: "PUT",
+				URL:    common.BaseOpenstackUrl + "/networking/v2.0/floatingips/" + fip.ID,
+				Body:   map[string]interface{}{"floatingip": map[string]string{"port_id": "{new port on " + network.NetworkID + "}"}},
+			}}
+		},
+	}
+}
@@ -0,0 +1,277 @@
+package app
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+func (a *AppHandler) setTargetRTO(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var rtoReq data.TargetRTORequest
+	err := json.NewDecoder(r.Body).Decode(&rtoReq)
+	if err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+	if rtoReq.TargetRTOSeconds < 0 {
+		http.Error(w, "target_rto_seconds must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	err = a.db.SetVMTargetRTO(id, rtoReq.TargetRTOSeconds)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	vm, err := a.db.GetVMInfo(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, vm)
+}
+
+// getRTOReport reports the recovery time achieved by each recovery, plan
+// run and bulk recovery, optionally for one VM or plan. With format=csv it
+// exports the recoveries, or the plan runs and bulk recoveries with by=plan.
+func (a *AppHandler) getRTOReport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	report, err := a.rtoReport(query.Get("vm_id"), query.Get("plan"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error building RTO report: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if query.Get("format") != "csv" {
+		rd.JSON(w, http.StatusOK, report)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	out := csv.NewWriter(w)
+	if query.Get("by") == "plan" {
+		w.Header().Set("Content-Disposition", "attachment; filename=rto-plans.csv")
+		out.Write([]string{"job_id", "kind", "plan", "status", "detected_at", "requested_at", "completed_at", "rto_seconds", "target_rto_seconds",
+			"met", "recoveries"})
+		for _, p := range report.Plans {
+			out.Write([]string{p.JobID, p.Kind, p.Plan, p.Status, formatTime(p.DetectedAt), formatTime(&p.RequestedAt), formatTime(p.CompletedAt),
+				formatSeconds(p.RTOSeconds), formatTarget(p.TargetRTOSeconds), formatMet(p.Met), strconv.Itoa(p.Recoveries)})
+		}
+	} else {
+		w.Header().Set("Content-Disposition", "attachment; filename=rto-recoveries.csv")
+		out.Write([]string{"job_id", "vm_id", "vm_name", "plan", "status", "detected_at", "requested_at", "decided_at", "started_at",
+			"validated_at", "completed_at", "rto_seconds", "target_rto_seconds", "met"})
+		for _, t := range report.Recoveries {
+			out.Write([]string{t.JobID, t.VMID, t.VMName, t.Plan, t.Status, formatTime(t.DetectedAt), formatTime(&t.RequestedAt),
+				formatTime(t.DecidedAt), formatTime(t.StartedAt), formatTime(t.ValidatedAt), formatTime(t.CompletedAt),
+				formatSeconds(t.RTOSeconds), formatTarget(t.TargetRTOSeconds), formatMet(t.Met)})
+		}
+	}
+	out.Flush()
+}
+
+func (a *AppHandler) rtoReport(vmID string, planName string) (data.RTOReport, error) {
+	report := data.RTOReport{Recoveries: []data.RecoveryTiming{}, Plans: []data.PlanTiming{}}
+
+	jobs, err := a.db.GetJobsByKind(data.JobKindRecover)
+	if err != nil {
+		return report, err
+	}
+
+	vms := make(map[string]*data.VMInstance)
+	vmOf := func(id string) *data.VMInstance {
+		if vm, ok := vms[id]; ok {
+			return vm
+		}
+		vm, err := a.db.GetVMInfo(id)
+		if err != nil {
+			vm = &data.VMInstance{ID: id}
+		}
+		vms[id] = vm
+		return vm
+	}
+
+	plans := make(map[string]string)
+	planOf := func(parentID string) string {
+		if parentID == "" {
+			return ""
+		}
+		if name, ok := plans[parentID]; ok {
+			return name
+		}
+		name := ""
+		parent, err := a.db.GetJob(parentID)
+		if err == nil && parent.Kind == data.JobKindPlan {
+			var planRun data.PlanRun
+			if json.Unmarshal(parent.Request, &planRun) == nil {
+				name = planRun.Plan.Name
+			}
+		}
+		plans[parentID] = name
+		return name
+	}
+
+	targets := make(map[string]int)
+	for _, job := range jobs {
+		if vmID != "" && job.VMID != vmID {
+			continue
+		}
+		plan := planOf(job.ParentID)
+		if planName != "" && plan != planName {
+			continue
+		}
+
+		timing := recoveryTiming(job, vmOf(job.VMID))
+		timing.Plan = plan
+		report.Recoveries = append(report.Recoveries, timing)
+		if timing.TargetRTOSeconds > targets[job.ParentID] {
+			targets[job.ParentID] = timing.TargetRTOSeconds
+		}
+	}
+
+	if vmID != "" {
+		return report, nil
+	}
+
+	var runs []*data.Job
+	for _, kind := range []string{data.JobKindPlan, data.JobKindBulk} {
+		jobs, err := a.db.GetJobsByKind(kind)
+		if err != nil {
+			return report, err
+		}
+		runs = append(runs, jobs...)
+	}
+	for _, run := range runs {
+		plan := planOf(run.ID)
+		if planName != "" && plan != planName {
+			continue
+		}
+
+		timing := data.PlanTiming{
+			JobID:            run.ID,
+			Kind:             run.Kind,
+			Plan:             plan,
+			Status:           run.Status,
+			RequestedAt:      run.CreatedAt,
+			CompletedAt:      run.FinishedAt,
+			TargetRTOSeconds: targets[run.ID],
+		}
+		for _, t := range report.Recoveries {
+			if t.ParentJobID != run.ID {
+				continue
+			}
+			timing.Recoveries++
+			if t.DetectedAt != nil && (timing.DetectedAt == nil || t.DetectedAt.Before(*timing.DetectedAt)) {
+				timing.DetectedAt = t.DetectedAt
+			}
+		}
+		if run.Status == data.JobSucceeded || run.Status == data.JobDegraded {
+			start := run.CreatedAt
+			if timing.DetectedAt != nil && timing.DetectedAt.Before(start) {
+				start = *timing.DetectedAt
+			}
+			timing.RTOSeconds, timing.Met = achieved(start, run.FinishedAt, timing.TargetRTOSeconds)
+		}
+		report.Plans = append(report.Plans, timing)
+	}
+
+	return report, nil
+}
+
+func recoveryTiming(job *data.Job, vm *data.VMInstance) data.RecoveryTiming {
+	timing := data.RecoveryTiming{
+		JobID:            job.ID,
+		ParentJobID:      job.ParentID,
+		VMID:             job.VMID,
+		VMName:           vm.Name,
+		Status:           job.Status,
+		RequestedAt:      job.CreatedAt,
+		StartedAt:        job.StartedAt,
+		CompletedAt:      job.FinishedAt,
+		Steps:            []data.StepTiming{},
+		TargetRTOSeconds: vm.TargetRTO,
+	}
+
+	var recoverReq data.RecoverRequest
+	if json.Unmarshal(job.Request, &recoverReq) == nil {
+		timing.DetectedAt = recoverReq.DetectedAt
+	}
+	if job.Plan != nil {
+		timing.DecidedAt = job.Plan.DecidedAt
+	}
+
+	for _, step := range job.Steps {
+		timing.Steps = append(timing.Steps, data.StepTiming{
+			Name:       step.Name,
+			Status:     step.Status,
+			StartedAt:  step.StartedAt,
+			FinishedAt: step.FinishedAt,
+		})
+		if step.Name == validateStep && step.Status == data.StepSucceeded {
+			timing.ValidatedAt = step.FinishedAt
+		}
+	}
+
+	if job.Status == data.JobSucceeded || job.Status == data.JobDegraded {
+		start := job.CreatedAt
+		if timing.DetectedAt != nil && timing.DetectedAt.Before(start) {
+			start = *timing.DetectedAt
+		}
+		timing.RTOSeconds, timing.Met = achieved(start, job.FinishedAt, vm.TargetRTO)
+	}
+
+	return timing
+}
+
+// achieved returns the seconds from start to end and, with a target,
+// whether they stayed within it.
+func achieved(start time.Time, end *time.Time, target int) (*float64, *bool) {
+	if end == nil {
+		return nil, nil
+	}
+	seconds := end.Sub(start).Seconds()
+	if target <= 0 {
+		return &seconds, nil
+	}
+	met := seconds <= float64(target)
+	return &seconds, &met
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatSeconds(s *float64) string {
+	if s == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*s, 'f', 1, 64)
+}
+
+func formatTarget(target int) string {
+	if target <= 0 {
+		return ""
+	}
+	return strconv.Itoa(target)
+}
+
+func formatMet(met *bool) string {
+	if met == nil {
+		return ""
+	}
+	return strconv.FormatBool(*met)
+}
@@ -0,0 +1,167 @@
+package app
+
+import (
+	"math"
+	"sort"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+const (
+	defaultStrategy = "ratio"
+	strategySetting = "strategy"
+)
+
+// SimilarityStrategy scores how well target could host the workloads of source.
+// Scores are in the range [0, 1] and are compared against the weight threshold.
+type SimilarityStrategy interface {
+	Name() string
+	Similarity(weight data.Weight, source, target data.VMInstance) float64
+}
+
+var strategies = map[string]SimilarityStrategy{
+	"ratio":   ratioStrategy{},
+	"jaccard": jaccardStrategy{},
+	"cosine":  cosineStrategy{},
+}
+
+func strategyNames() []string {
+	var names []string
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// strategyFor returns the strategy configured for vm, falling back to the
+// global setting and then to the default ratio strategy.
+func (a *AppHandler) strategyFor(vm *data.VMInstance) SimilarityStrategy {
+	if s, ok := strategies[vm.Strategy]; ok {
+		return s
+	}
+
+	name, err := a.db.GetSetting(strategySetting)
+	if err == nil {
+		if s, ok := strategies[name]; ok {
+			return s
+		}
+	}
+
+	return strategies[defaultStrategy]
+}
+
+// rankCandidates scores every other VM against source, best match first.
+func rankCandidates(strategy SimilarityStrategy, weight data.Weight, source *data.VMInstance, vms []*data.VMInstance) []data.Candidate {
+	var candidates []data.Candidate
+	for _, vm := range vms {
+		if vm.ID == source.ID {
+			continue
+		}
+		score := strategy.Similarity(weight, *source, *vm)
+		if math.IsNaN(score) {
+			score = 0
+		}
+		candidates = append(candidates, data.Candidate{ID: vm.ID, Name: vm.Name, Similarity: score, Score: score})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+
+	return candidates
+}
+
+// ratioStrategy is the original weighted share of the source's volumes
+// that are already present on the target.
+type ratioStrategy struct{}
+
+func (ratioStrategy) Name() string { return "ratio" }
+
+func (ratioStrategy) Similarity(weight data.Weight, source, target data.VMInstance) float64 {
+	return calculateSimilarity(weight, source, target)
+}
+
+// jaccardStrategy compares both software profiles as weighted multisets,
+// so software on the target that the source does not need lowers the score.
+type jaccardStrategy struct{}
+
+func (jaccardStrategy) Name() string { return "jaccard" }
+
+func (jaccardStrategy) Similarity(weight data.Weight, source, target data.VMInstance) float64 {
+	if source.OS != target.OS {
+		return 0.0
+	}
+
+	s := softwareFeatures(weight, source)
+	t := softwareFeatures(weight, target)
+
+	intersection := 0.0
+	union := 0.0
+	for key, sv := range s {
+		tv := t[key]
+		intersection += math.Min(sv, tv)
+		union += math.Max(sv, tv)
+	}
+	for key, tv := range t {
+		if _, ok := s[key]; !ok {
+			union += tv
+		}
+	}
+
+	if union == 0 {
+		return 0.0
+	}
+	return intersection / union
+}
+
+// cosineStrategy compares the direction of both feature vectors, which
+// favours targets running the same mix of software regardless of size.
+type cosineStrategy struct{}
+
+func (cosineStrategy) Name() string { return "cosine" }
+
+func (cosineStrategy) Similarity(weight data.Weight, source, target data.VMInstance) float64 {
+	if source.OS != target.OS {
+		return 0.0
+	}
+
+	s := softwareFeatures(weight, source)
+	t := softwareFeatures(weight, target)
+
+	dot := 0.0
+	sNorm := 0.0
+	tNorm := 0.0
+	for key, sv := range s {
+		dot += sv * t[key]
+		sNorm += sv * sv
+	}
+	for _, tv := range t {
+		tNorm += tv * tv
+	}
+
+	if sNorm == 0 || tNorm == 0 {
+		return 0.0
+	}
+	return dot / (math.Sqrt(sNorm) * math.Sqrt(tNorm))
+}
+
+// softwareFeatures turns a VM's volumes into a feature vector keyed by
+// category, volume ID and content, weighted by the category weight. Keys
+// match the way contains compares volumes, so every strategy agrees with
+// nonOverlappingVolumes on which volumes a target already has.
+func softwareFeatures(weight data.Weight, vm data.VMInstance) map[string]float64 {
+	features := make(map[string]float64)
+
+	for _, v := range vm.Software.Languages {
+		features["language/"+v.ID+"/"+v.Content] += float64(weight.Language)
+	}
+	for _, v := range vm.Software.Databases {
+		features["database/"+v.ID+"/"+v.Content] += float64(weight.Database)
+	}
+	for _, v := range vm.Software.Webservers {
+		features["webserver/"+v.ID+"/"+v.Content] += float64(weight.Webserver)
+	}
+
+	return features
+}
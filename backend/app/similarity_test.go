@@ -0,0 +1,59 @@
+package app
+
+import (
+	"math"
+	"testing"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+func vmWith(os string, languages, databases, webservers []data.Volume) data.VMInstance {
+	return data.VMInstance{
+		OS:       os,
+		Software: data.Software{Languages: languages, Databases: databases, Webservers: webservers},
+	}
+}
+
+func vol(id, content string) data.Volume {
+	return data.Volume{ID: id, Content: content}
+}
+
+func TestSimilarity(t *testing.T) {
+	weight := data.Weight{Language: 1, Database: 2, Webserver: 1}
+	python := vol("v1", "python")
+	mysql := vol("v2", "mysql")
+	nginx := vol("v3", "nginx")
+
+	source := vmWith("ubuntu", []data.Volume{python}, []data.Volume{mysql}, nil)
+
+	tests := []struct {
+		name     string
+		strategy SimilarityStrategy
+		target   data.VMInstance
+		want     float64
+	}{
+		{"ratio identical", ratioStrategy{}, source, 1},
+		{"ratio shares the language volume", ratioStrategy{}, vmWith("ubuntu", []data.Volume{python}, nil, nil), 1.0 / 3},
+		{"ratio needs the same volume", ratioStrategy{}, vmWith("ubuntu", []data.Volume{vol("v9", "python")}, nil, nil), 0},
+		{"ratio different OS", ratioStrategy{}, vmWith("centos", []data.Volume{python}, []data.Volume{mysql}, nil), 0},
+		{"jaccard identical", jaccardStrategy{}, source, 1},
+		{"jaccard counts extra software", jaccardStrategy{}, vmWith("ubuntu", []data.Volume{python}, nil, []data.Volume{nginx}), 0.25},
+		{"jaccard needs the same volume", jaccardStrategy{}, vmWith("ubuntu", []data.Volume{vol("v9", "python")}, []data.Volume{mysql}, nil), 2.0 / 4},
+		{"jaccard nothing in common", jaccardStrategy{}, vmWith("ubuntu", nil, nil, []data.Volume{nginx}), 0},
+		{"jaccard different OS", jaccardStrategy{}, vmWith("centos", []data.Volume{python}, []data.Volume{mysql}, nil), 0},
+		{"cosine identical", cosineStrategy{}, source, 1},
+		{"cosine partial overlap", cosineStrategy{}, vmWith("ubuntu", []data.Volume{python}, nil, []data.Volume{nginx}), 1 / math.Sqrt(10)},
+		{"cosine needs the same volume", cosineStrategy{}, vmWith("ubuntu", []data.Volume{vol("v9", "python")}, nil, nil), 0},
+		{"cosine empty target", cosineStrategy{}, vmWith("ubuntu", nil, nil, nil), 0},
+		{"cosine different OS", cosineStrategy{}, vmWith("centos", []data.Volume{python}, []data.Volume{mysql}, nil), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.strategy.Similarity(weight, source, tt.target)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Similarity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/common"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+const (
+	actionConsolidate = "consolidate"
+	actionRecreate    = "recreate"
+	actionSplit       = "split"
+	actionNone        = "none"
+)
+
+func (a *AppHandler) simulateFailure(w http.ResponseWriter, r *http.Request) {
+	var simulationReq data.SimulationRequest
+
+	err := json.NewDecoder(r.Body).Decode(&simulationReq)
+	if err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(simulationReq.VMIDs) == 0 && len(simulationReq.Hosts) == 0 && len(simulationReq.AvailabilityZones) == 0 {
+		http.Error(w, "at least one of vm_ids, hosts or availability_zones is required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := a.simulate(simulationReq)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error simulating failure: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, result)
+}
+
+// simulate plans the recovery of every VM matched by req without touching
+// OpenStack state. VMs are planned in inventory order so each decision sees
+// the capacity consumed by the ones before it.
+func (a *AppHandler) simulate(req data.SimulationRequest) (data.SimulationResult, error) {
+	result := data.SimulationResult{Failed: []string{}, Decisions: []data.SimulationDecision{}}
+
+	allVMs, err := a.db.GetVMsInfo()
+	if err != nil {
+		return result, fmt.Errorf("error fetching all VMs: %v", err)
+	}
+
+	failedVMs := matchFailedVMs(allVMs, req)
+	for _, vm := range failedVMs {
+		result.Failed = append(result.Failed, vm.ID)
+	}
+
+	p, err := a.newFailurePlanner(allVMs, failedVMs, req.Hosts)
+	if err != nil {
+		return result, fmt.Errorf("error preparing recovery planner: %v", err)
+	}
+
+	token := common.GetToken()
+
+	mode, err := a.recoveryMode("")
+	if err != nil {
+		return result, err
+	}
+
+	flavors := make(map[string]data.FlavorDetail)
+
+	for _, vm := range failedVMs {
+		plan := p.plan(vm, mode)
+		decision := data.SimulationDecision{
+			VMID:        vm.ID,
+			Name:        vm.Name,
+			Assignments: plan.Assignments,
+			Recreate:    plan.Recreate,
+		}
+
+		if plan.Recreate == nil {
+			decision.Action = actionConsolidate
+			result.Summary.Consolidated++
+			result.Decisions = append(result.Decisions, decision)
+			continue
+		}
+
+		flavor, ok := flavors[vm.FlavorID]
+		if !ok {
+			flavor, err = common.GetFlavor(token, vm.FlavorID)
+			if err != nil {
+				decision.Action = recreateAction(plan)
+				decision.Reason = fmt.Sprintf("flavor %s unknown, capacity not checked: %v", vm.FlavorID, err)
+				result.Summary.Recreated++
+				result.Decisions = append(result.Decisions, decision)
+				continue
+			}
+			flavors[vm.FlavorID] = flavor
+		}
+
+		hypervisor, ok := p.placeRecreate(flavor)
+		if !ok {
+			decision.Action = actionNone
+			decision.Reason = fmt.Sprintf("no surviving hypervisor fits flavor %s for %d unassigned volumes", flavor.Name, len(plan.Recreate.Volumes))
+			result.Summary.Unplaceable++
+			result.Decisions = append(result.Decisions, decision)
+			continue
+		}
+
+		decision.Action = recreateAction(plan)
+		if hypervisor != nil {
+			decision.RecreateHost = hypervisor.Service.Host
+		}
+		result.Summary.Recreated++
+		result.Decisions = append(result.Decisions, decision)
+	}
+
+	return result, nil
+}
+
+// recreateAction names a plan that recreates a VM, alone or alongside
+// consolidating some categories elsewhere.
+func recreateAction(plan data.RecoveryPlan) string {
+	if len(plan.Assignments) > 0 {
+		return actionSplit
+	}
+	return actionRecreate
+}
+
+// matchFailedVMs returns the VMs selected by ID, host or availability zone.
+func matchFailedVMs(vms []*data.VMInstance, req data.SimulationRequest) []*data.VMInstance {
+	ids := toSet(req.VMIDs)
+	hosts := toSet(req.Hosts)
+	zones := toSet(req.AvailabilityZones)
+
+	var failed []*data.VMInstance
+	for _, vm := range vms {
+		if ids[vm.ID] || (vm.Host != "" && hosts[vm.Host]) || (vm.AvailabilityZone != "" && zones[vm.AvailabilityZone]) {
+			failed = append(failed, vm)
+		}
+	}
+	return failed
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
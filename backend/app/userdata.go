@@ -0,0 +1,167 @@
+package app
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+// deviceWaitSeconds bounds how long the mount script waits for a volume to
+// show up, since the recreate path attaches volumes after the server boots.
+const deviceWaitSeconds = 600
+
+// mounts returns the volumes of vm with the given IDs that carry mount info.
+func mounts(vm *data.VMInstance, ids []string) []data.Volume {
+	wanted := toSet(ids)
+	var volumes []data.Volume
+	for _, group := range [][]data.Volume{vm.Software.Languages, vm.Software.Databases, vm.Software.Webservers} {
+		for _, v := range group {
+			if wanted[v.ID] && v.Mount != nil {
+				volumes = append(volumes, v)
+			}
+		}
+	}
+	return volumes
+}
+
+// mountScript builds a shell script that mounts volumes and starts the
+// services that use them. It is passed as cloud-init user-data to a
+// recreated VM and handed to operators for consolidation targets; running
+// it twice is harmless. A volume with invalid mount info, or whose
+// mountpoint already holds or is listed for another device, is not
+// mounted; the script then restarts no services and exits with an error.
+// It returns an empty string when there is nothing to mount.
+func mountScript(volumes []data.Volume) string {
+	if len(volumes) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString("# Mounts the volumes moved here by a disaster recovery.\n")
+	b.WriteString("set -e\n")
+	b.WriteString("failed=0\n\n")
+	fmt.Fprintf(&b, "wait_for() {\n\ti=0\n\twhile [ ! -e \"$1\" ]; do\n\t\tif [ $i -ge %d ]; then\n", deviceWaitSeconds)
+	b.WriteString("\t\t\techo \"device $1 did not appear\" >&2\n\t\t\texit 1\n\t\tfi\n\t\tsleep 5\n\t\ti=$((i + 5))\n\tdone\n}\n\n")
+	b.WriteString("# in_use succeeds when mountpoint $2 holds or is listed in /etc/fstab for\n")
+	b.WriteString("# a device other than $1.\n")
+	b.WriteString("in_use() {\n")
+	b.WriteString("\tcurrent=$(findmnt -rno SOURCE --mountpoint \"$2\" || true)\n")
+	b.WriteString("\tif [ -n \"$current\" ] && [ \"$(readlink -f \"$current\")\" != \"$(readlink -f \"$1\")\" ]; then\n")
+	b.WriteString("\t\treturn 0\n\tfi\n")
+	b.WriteString("\tawk -v dev=\"$1\" -v mp=\"$2\" '$1 !~ /^#/ && $2 == mp && $1 != dev { found = 1 } END { exit !found }' /etc/fstab 2>/dev/null\n")
+	b.WriteString("}\n")
+
+	var services []string
+	seen := make(map[string]bool)
+	for _, v := range volumes {
+		fmt.Fprintf(&b, "\n# %s\n", v.ID)
+		if err := v.Mount.Validate(); err != nil {
+			fmt.Fprintf(&b, "echo %s >&2\n", shellQuote(fmt.Sprintf("volume %s not mounted: %v", v.ID, err)))
+			b.WriteString("failed=1\n")
+			continue
+		}
+
+		device := shellQuote(devicePath(v.ID))
+		mountpoint := shellQuote(v.Mount.Mountpoint)
+		filesystem := v.Mount.Filesystem
+		if filesystem == "" {
+			filesystem = "auto"
+		}
+		entry := shellQuote(fmt.Sprintf("%s %s %s defaults,nofail 0 2", devicePath(v.ID), v.Mount.Mountpoint, filesystem))
+
+		fmt.Fprintf(&b, "wait_for %s\n", device)
+		fmt.Fprintf(&b, "if in_use %s %s; then\n", device, mountpoint)
+		fmt.Fprintf(&b, "\techo %s >&2\n", shellQuote(fmt.Sprintf("volume %s not mounted: %s is in use by another device", v.ID, v.Mount.Mountpoint)))
+		b.WriteString("\tfailed=1\n")
+		b.WriteString("else\n")
+		fmt.Fprintf(&b, "\tmkdir -p %s\n", mountpoint)
+		fmt.Fprintf(&b, "\tgrep -qs %s /etc/fstab || echo %s >> /etc/fstab\n", shellQuote(devicePath(v.ID)+" "), entry)
+		fmt.Fprintf(&b, "\tmountpoint -q %s || mount %s\n", mountpoint, mountpoint)
+		b.WriteString("fi\n")
+
+		if v.Mount.Service != "" && !seen[v.Mount.Service] {
+			seen[v.Mount.Service] = true
+			services = append(services, v.Mount.Service)
+		}
+	}
+
+	b.WriteString("\nif [ $failed -ne 0 ]; then\n")
+	b.WriteString("\techo \"not every volume was mounted, services were not restarted\" >&2\n")
+	b.WriteString("\texit 1\n")
+	b.WriteString("fi\n")
+	for _, service := range services {
+		fmt.Fprintf(&b, "systemctl enable %s\n", shellQuote(service))
+		fmt.Fprintf(&b, "systemctl restart %s\n", shellQuote(service))
+	}
+
+	return b.String()
+}
+
+// userData encodes a mount script as Nova user-data.
+func userData(script string) string {
+	if script == "" {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(script))
+}
+
+// devicePath is where a virtio volume appears in the guest; the udev link
+// carries the first 20 characters of the volume ID.
+func devicePath(volumeID string) string {
+	id := volumeID
+	if len(id) > 20 {
+		id = id[:20]
+	}
+	return "/dev/disk/by-id/virtio-" + id
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// getMountScript returns the mount script for one target of a recovery job,
+// for an operator or an agent on the target to run.
+func (a *AppHandler) getMountScript(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	target := r.URL.Query().Get("target")
+
+	job, err := a.db.GetJob(vars["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if job.Plan == nil {
+		http.Error(w, "job has no recovery plan", http.StatusNotFound)
+		return
+	}
+
+	var volumes []data.Volume
+	found := false
+	for _, assignment := range job.Plan.Assignments {
+		if assignment.TargetID == target || assignment.TargetName == target {
+			volumes = append(volumes, assignment.Mounts...)
+			found = true
+		}
+	}
+	if recreate := job.Plan.Recreate; recreate != nil && recreate.Name == target {
+		volumes = append(volumes, recreate.Mounts...)
+		found = true
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf("%q is not a target of job %s", target, job.ID), http.StatusNotFound)
+		return
+	}
+
+	script := mountScript(volumes)
+	if script == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "text/x-shellscript")
+	w.Write([]byte(script))
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+func TestMountScript(t *testing.T) {
+	const volumeID = "0123456789abcdefghijklmnop"
+	const device = "/dev/disk/by-id/virtio-0123456789abcdefghij"
+
+	mounted := func(mount data.MountInfo) data.Volume {
+		return data.Volume{ID: volumeID, Mount: &mount}
+	}
+
+	tests := []struct {
+		name    string
+		volumes []data.Volume
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "nothing to mount",
+		},
+		{
+			name:    "mounts and restarts the service",
+			volumes: []data.Volume{mounted(data.MountInfo{Filesystem: "ext4", Mountpoint: "/var/lib/mysql", Service: "mysql"})},
+			want: []string{
+				"wait_for '" + device + "'",
+				"if in_use '" + device + "' '/var/lib/mysql'; then",
+				"echo '" + device + " /var/lib/mysql ext4 defaults,nofail 0 2' >> /etc/fstab",
+				"mountpoint -q '/var/lib/mysql' || mount '/var/lib/mysql'",
+				"systemctl restart 'mysql'",
+			},
+		},
+		{
+			name:    "filesystem defaults to auto",
+			volumes: []data.Volume{mounted(data.MountInfo{Mountpoint: "/data"})},
+			want:    []string{" /data auto defaults,nofail 0 2"},
+			notWant: []string{"systemctl"},
+		},
+		{
+			name:    "quotes the mountpoint",
+			volumes: []data.Volume{mounted(data.MountInfo{Mountpoint: "/srv/it's"})},
+			want:    []string{`mkdir -p '/srv/it'\''s'`},
+		},
+		{
+			name:    "relative mountpoint is refused",
+			volumes: []data.Volume{mounted(data.MountInfo{Mountpoint: "data", Service: "nginx"})},
+			want:    []string{"is not an absolute path", "failed=1"},
+			notWant: []string{"mkdir", "/etc/fstab\n"},
+		},
+		{
+			name:    "whitespace is refused",
+			volumes: []data.Volume{mounted(data.MountInfo{Mountpoint: "/data\n/etc", Filesystem: "ext4"})},
+			want:    []string{"contains whitespace"},
+			notWant: []string{"mkdir"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := mountScript(tt.volumes)
+			if len(tt.volumes) == 0 {
+				if script != "" {
+					t.Errorf("mountScript() = %q, want empty", script)
+				}
+				return
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(script, want) {
+					t.Errorf("mountScript() does not contain %q:\n%s", want, script)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(script, notWant) {
+					t.Errorf("mountScript() contains %q:\n%s", notWant, script)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/common"
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+const (
+	defaultValidationTimeout = 5 * time.Minute
+	validateStep             = "validate recovery"
+)
+
+func (a *AppHandler) getValidation(w http.ResponseWriter, r *http.Request) {
+	config, err := a.db.GetValidation(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting validation: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, config)
+}
+
+func (a *AppHandler) setValidation(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var config data.ValidationConfig
+	err := json.NewDecoder(r.Body).Decode(&config)
+	if err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	for _, probe := range config.Probes {
+		if probe.Type != data.ProbeTCP && probe.Type != data.ProbeHTTP {
+			http.Error(w, fmt.Sprintf("probe type must be %q or %q", data.ProbeTCP, data.ProbeHTTP), http.StatusBadRequest)
+			return
+		}
+		if probe.Port <= 0 || probe.Port > 65535 {
+			http.Error(w, fmt.Sprintf("invalid probe port: %d", probe.Port), http.StatusBadRequest)
+			return
+		}
+	}
+	if config.Probes == nil {
+		config.Probes = []data.Probe{}
+	}
+
+	_, err = a.db.GetVMInfo(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	err = a.db.SetValidation(id, config)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error setting validation: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rd.JSON(w, http.StatusOK, config)
+}
+
+// validateRecovery checks every server that received workloads of the
+// failed VM: it must be ACTIVE, have each volume planned for it attached
+// and pass the probes configured for the failed VM. The results are stored
+// on the job; a failed check leaves the recovery degraded rather than
+// rolling it back.
+func (a *AppHandler) validateRecovery(run *jobRun, plan data.RecoveryPlan) {
+	run.step(validateStep, func() error {
+		config, err := a.db.GetValidation(plan.SourceID)
+		if err != nil {
+			return err
+		}
+		timeout := defaultValidationTimeout
+		if config.TimeoutSeconds > 0 {
+			timeout = time.Duration(config.TimeoutSeconds) * time.Second
+		}
+
+		token := common.GetToken()
+		var results []data.ValidationResult
+
+		expected := make(map[string][]string)
+		var servers []string
+		for _, assignment := range plan.Assignments {
+			if _, ok := expected[assignment.TargetID]; !ok {
+				servers = append(servers, assignment.TargetID)
+			}
+			expected[assignment.TargetID] = append(expected[assignment.TargetID], assignment.Volumes...)
+		}
+		if plan.Recreate != nil {
+			serverID, err := run.recreatedServer()
+			if err != nil {
+				results = append(results, data.ValidationResult{Server: plan.Recreate.Name, Check: "exists", Detail: err.Error()})
+			} else {
+				servers = append(servers, serverID)
+				expected[serverID] = append(expected[serverID], plan.Recreate.Volumes...)
+			}
+		}
+
+		for _, id := range servers {
+			results = append(results, validateServer(token, id, expected[id], config.Probes, timeout)...)
+		}
+
+		run.setValidation(results)
+
+		if validationFailed(results) {
+			return fmt.Errorf("post-recovery checks failed")
+		}
+		return nil
+	})
+}
+
+func validateServer(token string, id string, volumes []string, probes []data.Probe, timeout time.Duration) []data.ValidationResult {
+	server, err := waitForActive(token, id, timeout)
+	active := data.ValidationResult{ServerID: id, Server: server.Name, Check: "active", Passed: err == nil}
+	if err != nil {
+		active.Detail = err.Error()
+		return []data.ValidationResult{active}
+	}
+	results := []data.ValidationResult{active}
+
+	attached := make(map[string]bool)
+	for _, volume := range server.OsExtendedVolumesVolumesAttached {
+		attached[volume.ID] = true
+	}
+	for _, volumeID := range volumes {
+		result := data.ValidationResult{ServerID: id, Server: server.Name, Check: "volume " + volumeID, Passed: attached[volumeID]}
+		if !result.Passed {
+			result.Detail = "volume is not attached"
+		}
+		results = append(results, result)
+	}
+
+	ip := common.FixedIP(server)
+	for _, probe := range probes {
+		result := data.ValidationResult{ServerID: id, Server: server.Name, Check: fmt.Sprintf("%s %d", probe.Type, probe.Port)}
+		if ip == "" {
+			result.Detail = "server has no fixed IP"
+		} else if err := runProbe(ip, probe, timeout); err != nil {
+			result.Detail = err.Error()
+		} else {
+			result.Passed = true
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// waitForActive polls a server until it is ACTIVE, fails if it goes to
+// ERROR and gives up after timeout.
+func waitForActive(token string, id string, timeout time.Duration) (data.ServerDetail, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		server, err := common.GetServer(token, id)
+		if err != nil {
+			return server, fmt.Errorf("error fetching server: %v", err)
+		}
+		if server.Status == "ACTIVE" {
+			return server, nil
+		}
+		if server.Status == "ERROR" {
+			return server, fmt.Errorf("server is in ERROR state")
+		}
+		if time.Now().After(deadline) {
+			return server, fmt.Errorf("server is %s, not ACTIVE", server.Status)
+		}
+		time.Sleep(probeInterval)
+	}
+}
+
+func runProbe(ip string, probe data.Probe, timeout time.Duration) error {
+	address := net.JoinHostPort(ip, strconv.Itoa(probe.Port))
+	if probe.Type == data.ProbeHTTP {
+		return waitForHTTP("http://"+address+probe.Path, probe.ExpectStatus, timeout)
+	}
+	return waitForTCP(address, timeout)
+}
+
+// waitForHTTP polls url until it answers with the expected status, or with
+// any 2xx or 3xx status when expected is 0.
+func waitForHTTP(url string, expected int, timeout time.Duration) error {
+	client := &http.Client{Timeout: probeInterval}
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			if (expected == 0 && resp.StatusCode < 400) || resp.StatusCode == expected {
+				return nil
+			}
+			err = fmt.Errorf("received status %d", resp.StatusCode)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s: %v", url, err)
+		}
+		time.Sleep(probeInterval)
+	}
+}
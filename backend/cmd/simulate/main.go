@@ -0,0 +1,99 @@
+// Command simulate asks the recovery service what would happen if the given
+// VMs, hypervisors or availability zones failed. Nothing is changed.
+//
+//	go run ./cmd/simulate -host compute-1
+//	go run ./cmd/simulate -az nova -json
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+func main() {
+	api := flag.String("api", "http://localhost:8000", "recovery service base URL")
+	vms := flag.String("vm", "", "comma separated IDs of failed VMs")
+	hosts := flag.String("host", "", "comma separated failed hypervisor hosts")
+	zones := flag.String("az", "", "comma separated failed availability zones")
+	asJSON := flag.Bool("json", false, "print the raw plan as JSON")
+	flag.Parse()
+
+	simulationReq := data.SimulationRequest{
+		VMIDs:             splitList(*vms),
+		Hosts:             splitList(*hosts),
+		AvailabilityZones: splitList(*zones),
+	}
+	if len(simulationReq.VMIDs) == 0 && len(simulationReq.Hosts) == 0 && len(simulationReq.AvailabilityZones) == 0 {
+		fmt.Fprintln(os.Stderr, "one of -vm, -host or -az is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	body, err := json.Marshal(simulationReq)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	resp, err := http.Post(*api+"/simulate", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "Error: %d %s", resp.StatusCode, respBody)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		os.Stdout.Write(respBody)
+		return
+	}
+
+	var result data.SimulationResult
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VM\tACTION\tCATEGORY\tTARGET\tHOST\tSCORE\tVOLUMES\tREASON")
+	for _, d := range result.Decisions {
+		for _, as := range d.Assignments {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%.2f\t%d\t\n", d.Name, d.Action, as.Category, as.TargetName, as.TargetHost, as.Score, len(as.Volumes))
+		}
+		if d.Recreate != nil {
+			fmt.Fprintf(tw, "%s\t%s\t-\t%s\t%s\t-\t%d\t%s\n", d.Name, d.Action, d.Recreate.Name, d.RecreateHost, len(d.Recreate.Volumes), d.Reason)
+		}
+	}
+	tw.Flush()
+
+	fmt.Printf("\n%d failed: %d consolidated, %d recreated or split, %d without a viable option\n",
+		len(result.Failed), result.Summary.Consolidated, result.Summary.Recreated, result.Summary.Unplaceable)
+}
+
+func splitList(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
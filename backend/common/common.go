@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 
 	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
 )
@@ -14,6 +15,10 @@ var (
 	// PortForwarded Openstack VM IP
 	BaseOpenstackUrl = "http://10.125.70.26:8889"
 	ProjectId        = "66d5c0c9a8464550906e95d0b23c161f"
+	// Prometheus query API serving libvirt exporter metrics, if any
+	PrometheusUrl = os.Getenv("PROMETHEUS_URL")
+	// JSON file of VM utilisation used in place of Prometheus, if any
+	MetricsFile = os.Getenv("METRICS_FILE")
 )
 
 func GetToken() string {
@@ -0,0 +1,332 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+// ErrNotFound is returned when OpenStack reports that a resource does not exist.
+var ErrNotFound = errors.New("resource not found")
+
+// getJSON sends an authenticated GET request and decodes the JSON response into v.
+func getJSON(token string, url string, microversion string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %s", err)
+	}
+
+	req.Header.Set("X-Auth-Token", token)
+	req.Header.Set("content-type", "application/json")
+	if microversion != "" {
+		req.Header.Set("Openstack-API-Version", microversion)
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received non 200 response: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response body: %s", err)
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("error unmarshaling JSON: %s", err)
+	}
+
+	return nil
+}
+
+func GetFlavor(token string, id string) (data.FlavorDetail, error) {
+	var flavor data.FlavorResponse
+	err := getJSON(token, BaseOpenstackUrl+"/compute/v2.1/flavors/"+id, "", &flavor)
+	if err != nil {
+		return data.FlavorDetail{}, err
+	}
+	return flavor.Flavor, nil
+}
+
+func GetHypervisors(token string) ([]data.Hypervisor, error) {
+	var hypervisors data.HypervisorListResponse
+	err := getJSON(token, BaseOpenstackUrl+"/compute/v2.1/os-hypervisors/detail", "", &hypervisors)
+	if err != nil {
+		return nil, err
+	}
+	return hypervisors.Hypervisors, nil
+}
+
+// GetZoneHosts lists the compute hosts of an availability zone.
+func GetZoneHosts(token string, zone string) ([]string, error) {
+	var zones data.AvailabilityZoneResponse
+	err := getJSON(token, BaseOpenstackUrl+"/compute/v2.1/os-availability-zone/detail", "", &zones)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	for _, z := range zones.AvailabilityZoneInfo {
+		if z.ZoneName != zone {
+			continue
+		}
+		for host := range z.Hosts {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+func GetServerGroup(token string, id string) (data.ServerGroup, error) {
+	var group data.ServerGroupResponse
+	err := getJSON(token, BaseOpenstackUrl+"/compute/v2.1/os-server-groups/"+id, "compute 2.64", &group)
+	if err != nil {
+		return data.ServerGroup{}, err
+	}
+	if group.ServerGroup.Policy == "" && len(group.ServerGroup.Policies) > 0 {
+		group.ServerGroup.Policy = group.ServerGroup.Policies[0]
+	}
+	return group.ServerGroup, nil
+}
+
+// AttachVolume attaches a volume to a server through Nova.
+func AttachVolume(token string, serverID string, volumeID string) error {
+	_, err := AttachVolumeAt(token, serverID, volumeID, "")
+	return err
+}
+
+// AttachVolumeAt attaches a volume to a server, asking for device when it is
+// not empty, and returns the device Nova assigned.
+func AttachVolumeAt(token string, serverID string, volumeID string, device string) (string, error) {
+	attachReq := data.VolumeAttachmentsRequest{
+		VolumeAttachment: data.VolumeAttachment{
+			VolumeID: volumeID,
+			Device:   device,
+		},
+	}
+
+	body, err := json.Marshal(attachReq)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal volume attachment data: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", BaseOpenstackUrl+"/compute/v2.1/servers/"+serverID+"/os-volume_attachments", bytes.NewBuffer(body))
+	if err != nil {
+		return "", fmt.Errorf("error creating new request: %s", err)
+	}
+	req.Header.Set("X-Auth-Token", token)
+	req.Header.Set("content-type", "application/json")
+	// Openstack Nova Compute API Version 2.60 Include
+	req.Header.Set("Openstack-API-Version", "compute 2.60")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error sending volume attachment request: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to attach volume %s: received %d response", volumeID, resp.StatusCode)
+	}
+
+	var attached data.VolumeAttachmentsRequest
+	err = json.NewDecoder(resp.Body).Decode(&attached)
+	if err != nil {
+		return "", fmt.Errorf("error decoding volume attachment: %v", err)
+	}
+	return attached.VolumeAttachment.Device, nil
+}
+
+// GetVolumeAttachments lists the volumes attached to a server.
+func GetVolumeAttachments(token string, serverID string) ([]data.VolumeAttachment, error) {
+	var attachments data.VolumeAttachmentListResponse
+	err := getJSON(token, BaseOpenstackUrl+"/compute/v2.1/servers/"+serverID+"/os-volume_attachments", "compute 2.60", &attachments)
+	if err != nil {
+		return nil, err
+	}
+	return attachments.VolumeAttachments, nil
+}
+
+// sendJSON sends an authenticated request with an optional JSON body and
+// fails unless the response status is one of expected. When out is not nil
+// the response body is decoded into it.
+func sendJSON(token string, method string, url string, microversion string, in interface{}, out interface{}, expected ...int) error {
+	var reqBody io.Reader
+	if in != nil {
+		body, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("error marshaling request body: %v", err)
+		}
+		reqBody = bytes.NewBuffer(body)
+	}
+
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return fmt.Errorf("error creating request: %s", err)
+	}
+	req.Header.Set("X-Auth-Token", token)
+	req.Header.Set("content-type", "application/json")
+	if microversion != "" {
+		req.Header.Set("Openstack-API-Version", microversion)
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response body: %s", err)
+	}
+
+	ok := false
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			ok = true
+		}
+	}
+	if !ok {
+		return fmt.Errorf("%s %s received %d response: %s", method, url, resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(body, out); err != nil {
+			return fmt.Errorf("error unmarshaling JSON: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// DetachVolume detaches a volume from a server through Nova.
+func DetachVolume(token string, serverID string, volumeID string) error {
+	return sendJSON(token, "DELETE", BaseOpenstackUrl+"/compute/v2.1/servers/"+serverID+"/os-volume_attachments/"+volumeID,
+		"", nil, nil, http.StatusAccepted)
+}
+
+func DeleteServer(token string, serverID string) error {
+	return sendJSON(token, "DELETE", BaseOpenstackUrl+"/compute/v2.1/servers/"+serverID, "", nil, nil, http.StatusNoContent, http.StatusNotFound)
+}
+
+// GetServers lists every server with its details, following the "next"
+// links until Nova has returned every page. The listing is pinned to 2.46
+// because later microversions embed the flavor without its id; server group
+// membership is only reported by GetServer.
+func GetServers(token string) ([]data.ServerDetail, error) {
+	var all []data.ServerDetail
+	url := BaseOpenstackUrl + "/compute/v2.1/servers/detail"
+	for url != "" {
+		var servers data.OpenStackResponse
+		err := getJSON(token, url, "compute 2.46", &servers)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, servers.Servers...)
+
+		url = ""
+		for _, link := range servers.ServersLinks {
+			if link.Rel == "next" && len(servers.Servers) > 0 {
+				url = link.Href
+			}
+		}
+	}
+	return all, nil
+}
+
+// GetServer returns a server's details, including its lock state and
+// server group membership.
+func GetServer(token string, id string) (data.ServerDetail, error) {
+	var server data.ServerResponse
+	err := getJSON(token, BaseOpenstackUrl+"/compute/v2.1/servers/"+id, "compute 2.71", &server)
+	if err != nil {
+		return data.ServerDetail{}, err
+	}
+	return server.Server, nil
+}
+
+// Attributes returns the access settings of server.
+func Attributes(server data.ServerDetail) data.ServerAttributes {
+	attributes := data.ServerAttributes{
+		SecurityGroups: []string{},
+		KeyName:        server.KeyName,
+		Metadata:       make(map[string]string),
+		Tags:           []string{},
+	}
+	seen := make(map[string]bool)
+	for _, group := range server.SecurityGroups {
+		if !seen[group.Name] {
+			seen[group.Name] = true
+			attributes.SecurityGroups = append(attributes.SecurityGroups, group.Name)
+		}
+	}
+	for key, value := range server.Metadata {
+		attributes.Metadata[key] = fmt.Sprint(value)
+	}
+	attributes.Tags = append(attributes.Tags, server.Tags...)
+	return attributes
+}
+
+// FixedIP returns the first fixed IPv4 address of server, or "" if it has none.
+func FixedIP(server data.ServerDetail) string {
+	for _, addresses := range server.Addresses {
+		for _, address := range addresses {
+			if address.Type == "fixed" && address.Version == 4 {
+				return address.Addr
+			}
+		}
+	}
+	return ""
+}
+
+// ServerAction runs a server action such as os-stop or lock.
+func ServerAction(token string, id string, body interface{}) error {
+	return sendJSON(token, "POST", BaseOpenstackUrl+"/compute/v2.1/servers/"+id+"/action", "", body, nil, http.StatusAccepted)
+}
+
+func GetComputeServices(token string) ([]data.ComputeService, error) {
+	var services data.ComputeServiceListResponse
+	err := getJSON(token, BaseOpenstackUrl+"/compute/v2.1/os-services?binary=nova-compute", "compute 2.11", &services)
+	if err != nil {
+		return nil, err
+	}
+	return services.Services, nil
+}
+
+// GetLimits returns the project's absolute compute quota and usage.
+func GetLimits(token string) (data.AbsoluteLimits, error) {
+	var limits data.LimitsResponse
+	err := getJSON(token, BaseOpenstackUrl+"/compute/v2.1/limits", "", &limits)
+	if err != nil {
+		return data.AbsoluteLimits{}, err
+	}
+	return limits.Limits.Absolute, nil
+}
+
+// CreateServer asks Nova to boot a server and returns its ID.
+func CreateServer(token string, req data.CreateServerRequest) (string, error) {
+	var created data.ServerResponse
+	err := sendJSON(token, "POST", BaseOpenstackUrl+"/compute/v2.1/servers", "compute 2.71", req, &created, http.StatusAccepted)
+	if err != nil {
+		return "", err
+	}
+	return created.Server.ID, nil
+}
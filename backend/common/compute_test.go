@@ -0,0 +1,128 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetServersPaginates(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/compute/v2.1/servers/detail" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.URL.Query().Get("marker") {
+		case "":
+			fmt.Fprintf(w, `{"servers": [{"id": "a"}, {"id": "b"}],
+				"servers_links": [{"rel": "next", "href": "%s/compute/v2.1/servers/detail?marker=b"}]}`, server.URL)
+		case "b":
+			fmt.Fprintf(w, `{"servers": [{"id": "c"}],
+				"servers_links": [{"rel": "next", "href": "%s/compute/v2.1/servers/detail?marker=c"}]}`, server.URL)
+		default:
+			fmt.Fprint(w, `{"servers": []}`)
+		}
+	}))
+	defer server.Close()
+
+	base := BaseOpenstackUrl
+	BaseOpenstackUrl = server.URL
+	defer func() { BaseOpenstackUrl = base }()
+
+	servers, err := GetServers("token")
+	if err != nil {
+		t.Fatalf("GetServers() error = %v", err)
+	}
+
+	var ids []string
+	for _, s := range servers {
+		ids = append(ids, s.ID)
+	}
+	if fmt.Sprint(ids) != "[a b c]" {
+		t.Errorf("GetServers() ids = %v, want [a b c]", ids)
+	}
+}
+
+// server271 is a GET /servers/{id} response at compute 2.71, trimmed of
+// links and fields the inventory ignores.
+const server271 = `{"server": {
+	"id": "9168b536-cd40-4630-b43f-b259807c6e87",
+	"name": "web-1",
+	"status": "ACTIVE",
+	"locked": false,
+	"flavor": {
+		"disk": 1, "ephemeral": 0, "extra_specs": {}, "original_name": "m1.tiny",
+		"ram": 512, "swap": 0, "vcpus": 1
+	},
+	"image": "",
+	"key_name": null,
+	"metadata": {"role": "web"},
+	"tags": ["tier-1"],
+	"server_groups": ["a6e0e4c4-5c5e-4b8a-8f1c-6f3c7f7d2a10"],
+	"security_groups": [{"name": "default"}],
+	"addresses": {"private": [{"addr": "10.0.0.5", "version": 4,
+		"OS-EXT-IPS:type": "fixed", "OS-EXT-IPS-MAC:mac_addr": "fa:16:3e:4c:2c:30"}]},
+	"OS-EXT-AZ:availability_zone": "zone-a",
+	"OS-EXT-SRV-ATTR:host": "compute-1",
+	"OS-EXT-SRV-ATTR:root_device_name": "/dev/vda",
+	"OS-EXT-STS:vm_state": "active",
+	"os-extended-volumes:volumes_attached": [
+		{"id": "3bd3ea47-7f5a-4a36-a3b6-0f4c1d2e9a11", "delete_on_termination": false}
+	]
+}}`
+
+// servers246 is a GET /servers/detail response at compute 2.46, the last
+// microversion that reports the flavor id.
+const servers246 = `{"servers": [{
+	"id": "9168b536-cd40-4630-b43f-b259807c6e87",
+	"name": "web-1",
+	"flavor": {"id": "1", "links": []},
+	"image": {"id": "70a599e0-31e7-49b7-b260-868f441e862b", "links": []},
+	"OS-EXT-SRV-ATTR:host": "compute-1"
+}]}`
+
+func TestServerDecoding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := r.Header.Get("Openstack-API-Version")
+		switch {
+		case r.URL.Path == "/compute/v2.1/servers/detail" && version == "compute 2.46":
+			fmt.Fprint(w, servers246)
+		case r.URL.Path == "/compute/v2.1/servers/9168b536-cd40-4630-b43f-b259807c6e87" && version == "compute 2.71":
+			fmt.Fprint(w, server271)
+		default:
+			http.Error(w, "unexpected request "+r.URL.Path+" at "+version, http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	base := BaseOpenstackUrl
+	BaseOpenstackUrl = server.URL
+	defer func() { BaseOpenstackUrl = base }()
+
+	servers, err := GetServers("token")
+	if err != nil {
+		t.Fatalf("GetServers() error = %v", err)
+	}
+	if len(servers) != 1 || servers[0].Flavor.ID != "1" {
+		t.Errorf("GetServers() = %+v, want one server with flavor 1", servers)
+	}
+
+	detail, err := GetServer("token", "9168b536-cd40-4630-b43f-b259807c6e87")
+	if err != nil {
+		t.Fatalf("GetServer() error = %v", err)
+	}
+	if fmt.Sprint(detail.ServerGroups) != "[a6e0e4c4-5c5e-4b8a-8f1c-6f3c7f7d2a10]" {
+		t.Errorf("ServerGroups = %v", detail.ServerGroups)
+	}
+	if detail.Host != "compute-1" || detail.AvailabilityZone != "zone-a" {
+		t.Errorf("Host, AvailabilityZone = %q, %q", detail.Host, detail.AvailabilityZone)
+	}
+	if detail.OS.ID != "" || len(detail.OsExtendedVolumesVolumesAttached) != 1 {
+		t.Errorf("boot-from-volume image = %+v, volumes = %v", detail.OS, detail.OsExtendedVolumesVolumesAttached)
+	}
+	if FixedIP(detail) != "10.0.0.5" {
+		t.Errorf("FixedIP() = %q", FixedIP(detail))
+	}
+}
@@ -0,0 +1,106 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+const portPollInterval = 2 * time.Second
+
+// GetServerInterfaces lists the ports attached to a server.
+func GetServerInterfaces(token string, serverID string) ([]data.InterfaceAttachment, error) {
+	var interfaces data.InterfaceAttachmentListResponse
+	err := getJSON(token, BaseOpenstackUrl+"/compute/v2.1/servers/"+serverID+"/os-interface", "", &interfaces)
+	if err != nil {
+		return nil, err
+	}
+	return interfaces.InterfaceAttachments, nil
+}
+
+// GetServerNetworks returns the ports of a server with their fixed and
+// floating IPs.
+func GetServerNetworks(token string, serverID string) ([]data.NetworkInterface, error) {
+	interfaces, err := GetServerInterfaces(token, serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	networks := []data.NetworkInterface{}
+	for _, iface := range interfaces {
+		network := data.NetworkInterface{
+			NetworkID: iface.NetID,
+			PortID:    iface.PortID,
+			MACAddr:   iface.MACAddr,
+			FixedIPs:  []string{},
+		}
+		for _, ip := range iface.FixedIPs {
+			network.FixedIPs = append(network.FixedIPs, ip.IPAddress)
+		}
+		network.FloatingIPs, err = GetFloatingIPs(token, iface.PortID)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func GetFloatingIPs(token string, portID string) ([]data.FloatingIP, error) {
+	var floatingIPs data.FloatingIPListResponse
+	err := getJSON(token, BaseOpenstackUrl+"/networking/v2.0/floatingips?port_id="+url.QueryEscape(portID), "", &floatingIPs)
+	if err != nil {
+		return nil, err
+	}
+	return floatingIPs.FloatingIPs, nil
+}
+
+// AssociateFloatingIP points a floating IP at a port.
+func AssociateFloatingIP(token string, floatingIPID string, portID string) error {
+	body := map[string]interface{}{"floatingip": map[string]string{"port_id": portID}}
+	return sendJSON(token, "PUT", BaseOpenstackUrl+"/networking/v2.0/floatingips/"+floatingIPID, "", body, nil, http.StatusOK)
+}
+
+func GetPort(token string, portID string) (data.Port, error) {
+	var port data.PortResponse
+	err := getJSON(token, BaseOpenstackUrl+"/networking/v2.0/ports/"+portID, "", &port)
+	if err != nil {
+		return data.Port{}, err
+	}
+	return port.Port, nil
+}
+
+// DetachInterface asks Nova to detach a port from a server. Nova detaches
+// it asynchronously; WaitForPortFree tells when it is done.
+func DetachInterface(token string, serverID string, portID string) error {
+	return sendJSON(token, "DELETE", BaseOpenstackUrl+"/compute/v2.1/servers/"+serverID+"/os-interface/"+portID,
+		"", nil, nil, http.StatusAccepted)
+}
+
+// AttachInterface attaches an existing port to a server through Nova.
+func AttachInterface(token string, serverID string, portID string) error {
+	body := map[string]interface{}{"interfaceAttachment": map[string]string{"port_id": portID}}
+	return sendJSON(token, "POST", BaseOpenstackUrl+"/compute/v2.1/servers/"+serverID+"/os-interface",
+		"", body, nil, http.StatusOK)
+}
+
+// WaitForPortFree polls a port until no device uses it or the timeout expires.
+func WaitForPortFree(token string, portID string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		port, err := GetPort(token, portID)
+		if err != nil {
+			return err
+		}
+		if port.DeviceID == "" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for port %s to be detached from %s", portID, port.DeviceID)
+		}
+		time.Sleep(portPollInterval)
+	}
+}
@@ -0,0 +1,116 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+const volumePollInterval = 2 * time.Second
+
+func GetVolume(token string, id string) (data.VolumeDetail, error) {
+	var volume data.VolumeResponse
+	err := getJSON(token, BaseOpenstackUrl+"/volume/v3/"+ProjectId+"/volumes/"+id, "", &volume)
+	if err != nil {
+		return data.VolumeDetail{}, err
+	}
+	return volume.Volume, nil
+}
+
+// WaitForVolumeStatus polls a volume until it reaches status or the timeout expires.
+func WaitForVolumeStatus(token string, id string, status string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	last := ""
+	for {
+		volume, err := GetVolume(token, id)
+		if err != nil {
+			return err
+		}
+		if volume.Status == status {
+			return nil
+		}
+		if volume.Status == "error" || volume.Status == "error_detaching" {
+			return fmt.Errorf("volume %s is in %s state", id, volume.Status)
+		}
+		last = volume.Status
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for volume %s to become %s, last status %s", id, status, last)
+		}
+		time.Sleep(volumePollInterval)
+	}
+}
+
+// DeleteVolumeAttachment removes an attachment through the Cinder attachments
+// API, which does not need the compute host of the server to respond.
+func DeleteVolumeAttachment(token string, attachmentID string) error {
+	return sendJSON(token, "DELETE", BaseOpenstackUrl+"/volume/v3/"+ProjectId+"/attachments/"+attachmentID,
+		"volume 3.27", nil, nil, http.StatusOK, http.StatusNoContent)
+}
+
+// ForceDetachVolume clears an attachment in Cinder without involving Nova.
+func ForceDetachVolume(token string, volumeID string, attachmentID string) error {
+	body := map[string]interface{}{
+		"os-force_detach": map[string]string{
+			"attachment_id": attachmentID,
+		},
+	}
+	return sendJSON(token, "POST", BaseOpenstackUrl+"/volume/v3/"+ProjectId+"/volumes/"+volumeID+"/action",
+		"", body, nil, http.StatusAccepted)
+}
+
+// RootVolume returns the volume a boot-from-volume server boots from: the
+// bootable volume attached at its root device, which is boot index 0. It
+// returns an empty volume for servers booted from an image.
+func RootVolume(token string, server data.ServerDetail) (data.VolumeDetail, error) {
+	if server.OS.ID != "" {
+		return data.VolumeDetail{}, nil
+	}
+
+	var fallback data.VolumeDetail
+	for _, attached := range server.OsExtendedVolumesVolumesAttached {
+		volume, err := GetVolume(token, attached.ID)
+		if err != nil {
+			return data.VolumeDetail{}, fmt.Errorf("error fetching volume %s: %v", attached.ID, err)
+		}
+		if volume.Bootable != "true" {
+			continue
+		}
+		for _, attachment := range volume.Attachments {
+			if attachment.ServerID == server.ID && attachment.Device == server.RootDeviceName {
+				return volume, nil
+			}
+		}
+		if fallback.ID == "" {
+			fallback = volume
+		}
+	}
+	return fallback, nil
+}
+
+// VolumeImageName returns the name of the image a bootable volume was
+// created from.
+func VolumeImageName(volume data.VolumeDetail) string {
+	return volume.ImageMeta["image_name"]
+}
+
+// CloneVolume creates a copy of a volume and returns its ID.
+func CloneVolume(token string, sourceID string, name string) (string, error) {
+	body := map[string]interface{}{
+		"volume": map[string]string{
+			"source_volid": sourceID,
+			"name":         name,
+		},
+	}
+	var created data.VolumeResponse
+	err := sendJSON(token, "POST", BaseOpenstackUrl+"/volume/v3/"+ProjectId+"/volumes", "", body, &created, http.StatusAccepted)
+	if err != nil {
+		return "", err
+	}
+	return created.Volume.ID, nil
+}
+
+func DeleteVolume(token string, id string) error {
+	return sendJSON(token, "DELETE", BaseOpenstackUrl+"/volume/v3/"+ProjectId+"/volumes/"+id, "", nil, nil, http.StatusAccepted)
+}
@@ -0,0 +1,33 @@
+package data
+
+import "time"
+
+const (
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+)
+
+// ApprovalPolicy lists what a second operator has to approve before it
+// runs: recoveries of the given VMs and of VMs with volumes in the given
+// categories. Pending approvals expire after ExpirySeconds.
+type ApprovalPolicy struct {
+	VMIDs         []string `json:"vm_ids"`
+	Categories    []string `json:"categories"`
+	ExpirySeconds int      `json:"expiry_seconds"`
+}
+
+// Approval records why a job needed approval and who decided on it.
+type Approval struct {
+	Required    string     `json:"required"`
+	RequestedBy string     `json:"requested_by,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	Decision    string     `json:"decision,omitempty"`
+	DecidedBy   string     `json:"decided_by,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+}
+
+type ApprovalDecision struct {
+	Operator string `json:"operator"`
+	Reason   string `json:"reason"`
+}
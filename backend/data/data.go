@@ -1,10 +1,26 @@
 package data
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
 type Weight struct {
 	Language  float32 `json:"language"`
 	Database  float32 `json:"database"`
 	Webserver float32 `json:"webserver"`
 	Threshold float32 `json:"thtreshold"`
+	Load      float32 `json:"load"`
+}
+
+// Usage is the recent utilisation of a VM, each value a fraction of its capacity.
+type Usage struct {
+	CPU    float64 `json:"cpu"`
+	Memory float64 `json:"memory"`
+	DiskIO float64 `json:"disk_io"`
 }
 
 type Software struct {
@@ -14,8 +30,35 @@ type Software struct {
 }
 
 type Volume struct {
-	ID      string `json:"id"`
-	Content string `json:"content"`
+	ID      string     `json:"id"`
+	Content string     `json:"content"`
+	Mount   *MountInfo `json:"mount,omitempty"`
+
+	// Device is where Nova attached the volume, such as /dev/vdb.
+	Device string `json:"device,omitempty"`
+}
+
+// MountInfo says how a volume is used inside its VM: where it is mounted
+// and which service needs it.
+type MountInfo struct {
+	Filesystem string `json:"filesystem"`
+	Mountpoint string `json:"mountpoint"`
+	Service    string `json:"service,omitempty"`
+}
+
+// Validate checks that the mount info can be written to /etc/fstab: the
+// mountpoint is an absolute path and neither field holds whitespace.
+func (m *MountInfo) Validate() error {
+	if !strings.HasPrefix(m.Mountpoint, "/") {
+		return fmt.Errorf("mountpoint %q is not an absolute path", m.Mountpoint)
+	}
+	if strings.IndexFunc(m.Mountpoint, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("mountpoint %q contains whitespace", m.Mountpoint)
+	}
+	if strings.IndexFunc(m.Filesystem, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("filesystem %q contains whitespace", m.Filesystem)
+	}
+	return nil
 }
 
 type VMInstance struct {
@@ -24,6 +67,73 @@ type VMInstance struct {
 	FlavorID string   `json:"flavor_id"`
 	OS       string   `json:"os"`
 	Software Software `json:"software"`
+	Strategy string   `json:"strategy,omitempty"`
+
+	Host             string   `json:"host"`
+	AvailabilityZone string   `json:"availability_zone"`
+	ServerGroups     []string `json:"server_groups"`
+
+	// Status is empty for a live VM, or VMFailed / VMRecovered once a
+	// recovery has started or finished moving its workloads elsewhere.
+	// A replacement shut down by a failback is VMRetired.
+	Status string `json:"status,omitempty"`
+
+	Networks   []NetworkInterface `json:"networks,omitempty"`
+	Attributes ServerAttributes   `json:"attributes"`
+
+	// RootVolume is the volume a boot-from-volume VM boots from. It is not
+	// part of Software.
+	RootVolume string `json:"root_volume,omitempty"`
+
+	// TargetRTO is the recovery time objective of the VM in seconds, 0 if
+	// none was set.
+	TargetRTO int `json:"target_rto_seconds,omitempty"`
+}
+
+const (
+	VMFailed    = "failed"
+	VMRecovered = "recovered"
+	VMRetired   = "retired"
+
+	LineageConsolidate = "consolidate"
+	LineageRecreate    = "recreate"
+)
+
+type Candidate struct {
+	ID               string  `json:"id"`
+	Name             string  `json:"name"`
+	Host             string  `json:"host,omitempty"`
+	AvailabilityZone string  `json:"availability_zone,omitempty"`
+	Similarity       float64 `json:"similarity"`
+	Load             float64 `json:"load"`
+	Score            float64 `json:"score"`
+}
+
+type StrategyRequest struct {
+	Strategy string `json:"strategy"`
+}
+
+type FailureDomainRequest struct {
+	FailureDomain string `json:"failure_domain"`
+}
+
+type StrategyResponse struct {
+	Strategy  string   `json:"strategy"`
+	Available []string `json:"available"`
+}
+
+type StrategyComparison struct {
+	Strategy   string      `json:"strategy"`
+	Selected   *Candidate  `json:"selected"`
+	Action     string      `json:"action"`
+	Candidates []Candidate `json:"candidates"`
+}
+
+type SimilarityReport struct {
+	VMID        string               `json:"vm_id"`
+	Threshold   float32              `json:"threshold"`
+	Active      string               `json:"active_strategy"`
+	Comparisons []StrategyComparison `json:"comparisons"`
 }
 
 type Payload struct {
@@ -54,14 +164,30 @@ type Domain struct {
 }
 
 type VolumeDetail struct {
-	ID       string   `json:"id"`
-	Name     string   `json:"name"`
-	Metadata Metadata `json:"metadata"`
+	ID          string                 `json:"id"`
+	Name        string                 `json:"name"`
+	Metadata    Metadata               `json:"metadata"`
+	Status      string                 `json:"status"`
+	Multiattach bool                   `json:"multiattach"`
+	Attachments []VolumeAttachmentInfo `json:"attachments"`
+	Size        int                    `json:"size"`
+	Bootable    string                 `json:"bootable"`
+	ImageMeta   map[string]string      `json:"volume_image_metadata"`
+}
+
+type VolumeAttachmentInfo struct {
+	ID           string `json:"id"`
+	AttachmentID string `json:"attachment_id"`
+	ServerID     string `json:"server_id"`
+	Device       string `json:"device"`
 }
 
 type Metadata struct {
-	Type    string `json:"type"`
-	Content string `json:"content"`
+	Type       string `json:"type"`
+	Content    string `json:"content"`
+	Filesystem string `json:"filesystem"`
+	Mountpoint string `json:"mountpoint"`
+	Service    string `json:"service"`
 }
 
 type VolumeResponse struct {
@@ -79,24 +205,121 @@ type SummaryDetail struct {
 }
 
 type ServerDetail struct {
-	ID                               string                 `json:"id"`
-	Name                             string                 `json:"name"`
-	Flavor                           Flavor                 `json:"flavor"`
-	OS                               ImageDetail            `json:"image"`
-	OsExtendedVolumesVolumesAttached []AttachVolumeID       `json:"os-extended-volumes:volumes_attached"`
-	Metadata                         map[string]interface{} `json:"metadata"`
+	ID                               string                     `json:"id"`
+	Name                             string                     `json:"name"`
+	Flavor                           Flavor                     `json:"flavor"`
+	OS                               ImageDetail                `json:"image"`
+	OsExtendedVolumesVolumesAttached []AttachVolumeID           `json:"os-extended-volumes:volumes_attached"`
+	Metadata                         map[string]interface{}     `json:"metadata"`
+	Host                             string                     `json:"OS-EXT-SRV-ATTR:host"`
+	AvailabilityZone                 string                     `json:"OS-EXT-AZ:availability_zone"`
+	ServerGroups                     []string                   `json:"server_groups"`
+	Status                           string                     `json:"status"`
+	VMState                          string                     `json:"OS-EXT-STS:vm_state"`
+	Locked                           bool                       `json:"locked"`
+	RootDeviceName                   string                     `json:"OS-EXT-SRV-ATTR:root_device_name"`
+	SecurityGroups                   []SecurityGroupName        `json:"security_groups"`
+	KeyName                          string                     `json:"key_name"`
+	Tags                             []string                   `json:"tags"`
+	Addresses                        map[string][]ServerAddress `json:"addresses"`
+}
+
+type ServerAddress struct {
+	Addr    string `json:"addr"`
+	Version int    `json:"version"`
+	Type    string `json:"OS-EXT-IPS:type"`
+	MACAddr string `json:"OS-EXT-IPS-MAC:mac_addr"`
+}
+
+type ServerResponse struct {
+	Server ServerDetail `json:"server"`
+}
+
+type ComputeService struct {
+	ID         interface{} `json:"id"`
+	Binary     string      `json:"binary"`
+	Host       string      `json:"host"`
+	Zone       string      `json:"zone"`
+	Status     string      `json:"status"`
+	State      string      `json:"state"`
+	ForcedDown bool        `json:"forced_down"`
+}
+
+type ComputeServiceListResponse struct {
+	Services []ComputeService `json:"services"`
 }
 
 type Flavor struct {
 	ID string `json:"id"`
 }
 
+type FlavorDetail struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Vcpus int    `json:"vcpus"`
+	Ram   int    `json:"ram"`
+	Disk  int    `json:"disk"`
+}
+
+type FlavorResponse struct {
+	Flavor FlavorDetail `json:"flavor"`
+}
+
+type Hypervisor struct {
+	ID           interface{}       `json:"id"`
+	Hostname     string            `json:"hypervisor_hostname"`
+	State        string            `json:"state"`
+	Status       string            `json:"status"`
+	Vcpus        int               `json:"vcpus"`
+	VcpusUsed    int               `json:"vcpus_used"`
+	MemoryMB     int               `json:"memory_mb"`
+	MemoryMBUsed int               `json:"memory_mb_used"`
+	Service      HypervisorService `json:"service"`
+}
+
+type AvailabilityZoneResponse struct {
+	AvailabilityZoneInfo []AvailabilityZone `json:"availabilityZoneInfo"`
+}
+
+type AvailabilityZone struct {
+	ZoneName string                 `json:"zoneName"`
+	Hosts    map[string]interface{} `json:"hosts"`
+}
+
+type HypervisorService struct {
+	ID   interface{} `json:"id"`
+	Host string      `json:"host"`
+}
+
+type HypervisorListResponse struct {
+	Hypervisors []Hypervisor `json:"hypervisors"`
+}
+
+// ServerGroup is a Nova server group. Policy is reported from compute
+// microversion 2.64 on; older versions list it in Policies.
+type ServerGroup struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Policy   string   `json:"policy"`
+	Policies []string `json:"policies"`
+}
+
+type ServerGroupResponse struct {
+	ServerGroup ServerGroup `json:"server_group"`
+}
+
 type AttachVolumeID struct {
 	ID string `json:"id"`
 }
 
 type OpenStackResponse struct {
-	Servers []ServerDetail `json:"servers"`
+	Servers      []ServerDetail `json:"servers"`
+	ServersLinks []Link         `json:"servers_links"`
+}
+
+type Link struct {
+	Href string `json:"href"`
+	Rel  string `json:"rel"`
 }
 
 type InstanceRequest struct {
@@ -116,10 +339,137 @@ type ImageDetail struct {
 	ID   string `json:"id"`
 }
 
+// UnmarshalJSON accepts the empty string Nova reports as the image of a
+// boot-from-volume server.
+func (i *ImageDetail) UnmarshalJSON(b []byte) error {
+	var name string
+	if json.Unmarshal(b, &name) == nil {
+		*i = ImageDetail{}
+		return nil
+	}
+	type image ImageDetail
+	return json.Unmarshal(b, (*image)(i))
+}
+
 type VolumeAttachmentsRequest struct {
 	VolumeAttachment VolumeAttachment `json:"volumeAttachment"`
 }
 
 type VolumeAttachment struct {
 	VolumeID string `json:"volumeId"`
+	Device   string `json:"device,omitempty"`
+}
+
+type VolumeAttachmentListResponse struct {
+	VolumeAttachments []VolumeAttachment `json:"volumeAttachments"`
+}
+
+type SimulationRequest struct {
+	VMIDs             []string `json:"vm_ids"`
+	Hosts             []string `json:"hosts"`
+	AvailabilityZones []string `json:"availability_zones"`
+}
+
+type SimulationDecision struct {
+	VMID         string        `json:"vm_id"`
+	Name         string        `json:"name"`
+	Action       string        `json:"action"`
+	Assignments  []Assignment  `json:"assignments"`
+	Recreate     *RecreateSpec `json:"recreate,omitempty"`
+	RecreateHost string        `json:"recreate_host,omitempty"`
+	Reason       string        `json:"reason,omitempty"`
+}
+
+type SimulationSummary struct {
+	Consolidated int `json:"consolidated"`
+	Recreated    int `json:"recreated"`
+	Unplaceable  int `json:"unplaceable"`
+}
+
+type SimulationResult struct {
+	Failed    []string             `json:"failed"`
+	Decisions []SimulationDecision `json:"decisions"`
+	Summary   SimulationSummary    `json:"summary"`
+}
+
+type RecoverRequest struct {
+	Mode string `json:"mode"`
+	// DetectedAt is when the failure was detected, if earlier than the
+	// request. RTO is measured from it.
+	DetectedAt *time.Time `json:"detected_at,omitempty"`
+	// Operator is who asked for the recovery. When approval is required,
+	// a different operator has to approve it.
+	Operator string `json:"operator,omitempty"`
+	// ResetState also resets the failed server to the error state while fencing.
+	ResetState    bool           `json:"reset_state,omitempty"`
+	FenceOverride *FenceOverride `json:"fence_override,omitempty"`
+}
+
+// FenceOverride lets an operator proceed with a recovery when the failed VM
+// could not be confirmed as stopped, recording who decided and why.
+type FenceOverride struct {
+	Operator string `json:"operator"`
+	Reason   string `json:"reason"`
+}
+
+type FencingResult struct {
+	Confirmed    bool           `json:"confirmed"`
+	ServerStatus string         `json:"server_status,omitempty"`
+	Locked       bool           `json:"locked"`
+	Detail       string         `json:"detail,omitempty"`
+	Override     *FenceOverride `json:"override,omitempty"`
+}
+
+// RecoveryPlan describes how the volumes of a failed VM are brought back:
+// consolidated onto existing VMs, recreated on a new VM, or both.
+type RecoveryPlan struct {
+	SourceID    string        `json:"source_id"`
+	SourceName  string        `json:"source_name"`
+	Mode        string        `json:"mode"`
+	Assignments []Assignment  `json:"assignments"`
+	Recreate    *RecreateSpec `json:"recreate,omitempty"`
+	DecidedAt   *time.Time    `json:"decided_at,omitempty"`
+
+	// Devices maps each volume of the source to the device it had there.
+	Devices map[string]string `json:"devices,omitempty"`
+}
+
+type Assignment struct {
+	TargetID   string   `json:"target_id"`
+	TargetName string   `json:"target_name"`
+	TargetHost string   `json:"target_host,omitempty"`
+	Category   string   `json:"category"`
+	Score      float64  `json:"score"`
+	Volumes    []string `json:"volumes"`
+
+	// Mounts are the moved volumes that carry mount info; the target needs
+	// the mount script built from them to use the volumes.
+	Mounts []Volume `json:"mounts,omitempty"`
+}
+
+type RecreateSpec struct {
+	Name     string             `json:"name"`
+	OS       string             `json:"os"`
+	FlavorID string             `json:"flavor_id"`
+	Volumes  []string           `json:"volumes"`
+	Networks []NetworkInterface `json:"networks,omitempty"`
+
+	Attributes   ServerAttributes `json:"attributes"`
+	ServerGroups []string         `json:"server_groups,omitempty"`
+
+	// RootVolume, when set, is booted from instead of the OS image.
+	RootVolume string `json:"root_volume,omitempty"`
+
+	// Mounts are mounted by the replacement's user-data.
+	Mounts []Volume `json:"mounts,omitempty"`
+}
+
+// BulkRecoverRequest selects failed VMs by ID, host or availability zone
+// and applies the same recovery options to all of them.
+type BulkRecoverRequest struct {
+	VMIDs            []string `json:"vm_ids"`
+	Host             string   `json:"host"`
+	AvailabilityZone string   `json:"availability_zone"`
+	Parallelism      int      `json:"parallelism"`
+	RecoverRequest
 }
@@ -0,0 +1,54 @@
+package data
+
+// APICall is an OpenStack request a recovery would issue.
+type APICall struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Body   interface{} `json:"body,omitempty"`
+	Note   string      `json:"note,omitempty"`
+}
+
+type DryRunStep struct {
+	Name  string    `json:"name"`
+	Calls []APICall `json:"calls"`
+}
+
+// DryRunResult is what a recovery or failback would do, computed from the current
+// OpenStack state without changing it.
+type DryRunResult struct {
+	Plan     *RecoveryPlan `json:"plan,omitempty"`
+	Failback *FailbackPlan `json:"failback,omitempty"`
+	Steps    []DryRunStep  `json:"steps"`
+	Quota    *QuotaCheck   `json:"quota,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// QuotaCheck compares what a recreation needs with the project's remaining
+// compute quota. A negative available value means unlimited.
+type QuotaCheck struct {
+	Sufficient bool          `json:"sufficient"`
+	Requested  ComputeAmount `json:"requested"`
+	Available  ComputeAmount `json:"available"`
+	Detail     string        `json:"detail,omitempty"`
+}
+
+type ComputeAmount struct {
+	Cores     int `json:"cores"`
+	RAM       int `json:"ram"`
+	Instances int `json:"instances"`
+}
+
+type AbsoluteLimits struct {
+	MaxTotalCores      int `json:"maxTotalCores"`
+	TotalCoresUsed     int `json:"totalCoresUsed"`
+	MaxTotalRAMSize    int `json:"maxTotalRAMSize"`
+	TotalRAMUsed       int `json:"totalRAMUsed"`
+	MaxTotalInstances  int `json:"maxTotalInstances"`
+	TotalInstancesUsed int `json:"totalInstancesUsed"`
+}
+
+type LimitsResponse struct {
+	Limits struct {
+		Absolute AbsoluteLimits `json:"absolute"`
+	} `json:"limits"`
+}
@@ -0,0 +1,41 @@
+package data
+
+import "time"
+
+const (
+	HealthHealthy  = "healthy"
+	HealthDegraded = "degraded"
+	HealthFailed   = "failed"
+	HealthUnknown  = "unknown"
+)
+
+// VMHealth is the last state the failure monitor saw for a VM. Since is
+// when the VM entered State.
+type VMHealth struct {
+	VMID         string    `json:"vm_id"`
+	Name         string    `json:"name"`
+	State        string    `json:"state"`
+	ServerStatus string    `json:"server_status,omitempty"`
+	VMState      string    `json:"vm_state,omitempty"`
+	Host         string    `json:"host,omitempty"`
+	HostState    string    `json:"host_state,omitempty"`
+	Detail       string    `json:"detail,omitempty"`
+	Since        time.Time `json:"since"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+// HealthSummary counts the VMs of the fleet per health state and lists the
+// ones that are not healthy.
+type HealthSummary struct {
+	Total     int            `json:"total"`
+	States    map[string]int `json:"states"`
+	Hosts     []HostHealth   `json:"hosts"`
+	Unhealthy []VMHealth     `json:"unhealthy"`
+	CheckedAt *time.Time     `json:"checked_at,omitempty"`
+}
+
+type HostHealth struct {
+	Host   string         `json:"host"`
+	State  string         `json:"state,omitempty"`
+	States map[string]int `json:"states"`
+}
@@ -0,0 +1,118 @@
+package data
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	JobQueued      = "queued"
+	JobRunning     = "running"
+	JobSucceeded   = "succeeded"
+	JobFailed      = "failed"
+	JobInterrupted = "interrupted"
+	JobRolledBack  = "rolled_back"
+	JobSkipped     = "skipped"
+	JobDegraded    = "degraded"
+
+	JobPendingApproval = "pending_approval"
+	JobRejected        = "rejected"
+	JobExpired         = "expired"
+
+	JobKindRecover  = "recover"
+	JobKindCreate   = "create"
+	JobKindBulk     = "bulk"
+	JobKindPlan     = "plan"
+	JobKindFailback = "failback"
+
+	StepRunning   = "running"
+	StepSucceeded = "succeeded"
+	StepFailed    = "failed"
+
+	RollbackCompleted  = "completed"
+	RollbackIncomplete = "incomplete"
+)
+
+// Job is a persisted recovery or create request executed by a worker.
+type Job struct {
+	ID         string             `json:"id"`
+	Kind       string             `json:"kind"`
+	ParentID   string             `json:"parent_id,omitempty"`
+	Owner      string             `json:"owner,omitempty"`
+	VMID       string             `json:"vm_id,omitempty"`
+	Status     string             `json:"status"`
+	Request    json.RawMessage    `json:"request,omitempty"`
+	Plan       *RecoveryPlan      `json:"plan,omitempty"`
+	Failback   *FailbackPlan      `json:"failback,omitempty"`
+	Fencing    *FencingResult     `json:"fencing,omitempty"`
+	Targets    []string           `json:"targets"`
+	Steps      []JobStep          `json:"steps"`
+	Error      string             `json:"error,omitempty"`
+	Rollback   string             `json:"rollback,omitempty"`
+	Validation []ValidationResult `json:"validation,omitempty"`
+	Approval   *Approval          `json:"approval,omitempty"`
+	Devices    []DeviceMapping    `json:"devices,omitempty"`
+	CreatedAt  time.Time          `json:"created_at"`
+	StartedAt  *time.Time         `json:"started_at,omitempty"`
+	FinishedAt *time.Time         `json:"finished_at,omitempty"`
+	UpdatedAt  time.Time          `json:"updated_at"`
+
+	// Children summarises the per-VM jobs of a bulk or plan job. It is filled in
+	// when the job is read and not stored with it.
+	Children []JobSummary `json:"children,omitempty"`
+}
+
+// DeviceMapping records where a recovery attached a volume. Remapped is set
+// when the volume did not get the device it had on the failed VM.
+type DeviceMapping struct {
+	VolumeID string `json:"volume_id"`
+	ServerID string `json:"server_id"`
+	Original string `json:"original,omitempty"`
+	Device   string `json:"device"`
+	Remapped bool   `json:"remapped"`
+}
+
+type JobSummary struct {
+	ID      string   `json:"id"`
+	VMID    string   `json:"vm_id"`
+	Status  string   `json:"status"`
+	Targets []string `json:"targets"`
+	Error   string   `json:"error,omitempty"`
+}
+
+type JobStep struct {
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+
+	// Compensation is the outcome of undoing this step during a rollback.
+	Compensation      string `json:"compensation,omitempty"`
+	CompensationError string `json:"compensation_error,omitempty"`
+}
+
+type JobAccepted struct {
+	JobID    string        `json:"job_id"`
+	Status   string        `json:"status"`
+	Location string        `json:"location"`
+	Plan     *RecoveryPlan `json:"plan,omitempty"`
+	Failback *FailbackPlan `json:"failback,omitempty"`
+}
+
+// ConflictResponse explains a 409 caused by another in-flight job.
+type ConflictResponse struct {
+	Error string `json:"error"`
+	JobID string `json:"job_id,omitempty"`
+}
+
+// IdempotencyRecord remembers the outcome of a request sent with an
+// Idempotency-Key header. A zero StatusCode means it is still in progress.
+type IdempotencyRecord struct {
+	Key         string
+	RequestHash string
+	JobID       string
+	StatusCode  int
+	Response    json.RawMessage
+	CreatedAt   time.Time
+}
@@ -0,0 +1,36 @@
+package data
+
+import "time"
+
+// Lineage links a failed VM to a VM that took over some of its volumes.
+type Lineage struct {
+	SourceID      string    `json:"source_id"`
+	ReplacementID string    `json:"replacement_id"`
+	JobID         string    `json:"job_id"`
+	Kind          string    `json:"kind"`
+	Volumes       []string  `json:"volumes"`
+	CreatedAt     time.Time `json:"created_at"`
+
+	// RootVolume is the failed VM's root volume when a recreated
+	// replacement boots from it.
+	RootVolume string `json:"root_volume,omitempty"`
+
+	// Devices are the devices the volumes had on the failed VM, which a
+	// failback attaches them on again.
+	Devices map[string]string `json:"devices,omitempty"`
+
+	// FailedBackAt is set once the volumes went back to the source.
+	FailedBackAt *time.Time `json:"failed_back_at,omitempty"`
+}
+
+// FailbackPlan returns the volumes of a repaired VM from every replacement
+// that took them over when it failed.
+type FailbackPlan struct {
+	SourceID   string    `json:"source_id"`
+	SourceName string    `json:"source_name"`
+	Links      []Lineage `json:"links"`
+}
+
+type FailbackRequest struct {
+	Operator string `json:"operator,omitempty"`
+}
@@ -0,0 +1,102 @@
+package data
+
+// NetworkInterface is one port of a server as recorded in the inventory.
+type NetworkInterface struct {
+	NetworkID   string       `json:"network_id"`
+	PortID      string       `json:"port_id"`
+	MACAddr     string       `json:"mac_addr,omitempty"`
+	FixedIPs    []string     `json:"fixed_ips"`
+	FloatingIPs []FloatingIP `json:"floating_ips,omitempty"`
+}
+
+type FloatingIP struct {
+	ID      string `json:"id"`
+	Address string `json:"floating_ip_address"`
+	PortID  string `json:"port_id,omitempty"`
+}
+
+type FloatingIPListResponse struct {
+	FloatingIPs []FloatingIP `json:"floatingips"`
+}
+
+type InterfaceAttachment struct {
+	PortID   string `json:"port_id"`
+	NetID    string `json:"net_id"`
+	MACAddr  string `json:"mac_addr"`
+	FixedIPs []struct {
+		IPAddress string `json:"ip_address"`
+		SubnetID  string `json:"subnet_id"`
+	} `json:"fixed_ips"`
+}
+
+type InterfaceAttachmentListResponse struct {
+	InterfaceAttachments []InterfaceAttachment `json:"interfaceAttachments"`
+}
+
+// Port holds the binding of a Neutron port to the server using it.
+type Port struct {
+	ID          string `json:"id"`
+	NetworkID   string `json:"network_id"`
+	DeviceID    string `json:"device_id"`
+	DeviceOwner string `json:"device_owner"`
+	BindingHost string `json:"binding:host_id"`
+}
+
+type PortResponse struct {
+	Port Port `json:"port"`
+}
+
+// ServerNetwork requests a network for a new server: an existing port, or
+// a network with an optional fixed IP.
+type ServerNetwork struct {
+	UUID    string `json:"uuid,omitempty"`
+	Port    string `json:"port,omitempty"`
+	FixedIP string `json:"fixed_ip,omitempty"`
+}
+
+// ServerCreate is the body of a Nova create server request. Networks is
+// either a list of ServerNetwork or "auto".
+type ServerCreate struct {
+	Name           string              `json:"name"`
+	ImageRef       string              `json:"imageRef"`
+	FlavorRef      string              `json:"flavorRef"`
+	Networks       interface{}         `json:"networks"`
+	SecurityGroups []SecurityGroupName `json:"security_groups,omitempty"`
+	KeyName        string              `json:"key_name,omitempty"`
+	Metadata       map[string]string   `json:"metadata,omitempty"`
+	Tags           []string            `json:"tags,omitempty"`
+	UserData       string              `json:"user_data,omitempty"`
+
+	BlockDeviceMapping []BlockDevice `json:"block_device_mapping_v2,omitempty"`
+}
+
+type BlockDevice struct {
+	BootIndex           int    `json:"boot_index"`
+	UUID                string `json:"uuid"`
+	SourceType          string `json:"source_type"`
+	DestinationType     string `json:"destination_type"`
+	DeleteOnTermination bool   `json:"delete_on_termination"`
+}
+
+type CreateServerRequest struct {
+	Server         ServerCreate    `json:"server"`
+	SchedulerHints *SchedulerHints `json:"os:scheduler_hints,omitempty"`
+}
+
+// SchedulerHints places a new server into a server group.
+type SchedulerHints struct {
+	Group string `json:"group,omitempty"`
+}
+
+type SecurityGroupName struct {
+	Name string `json:"name"`
+}
+
+// ServerAttributes are the access settings of a server that a replacement
+// has to carry over.
+type ServerAttributes struct {
+	SecurityGroups []string          `json:"security_groups"`
+	KeyName        string            `json:"key_name,omitempty"`
+	Metadata       map[string]string `json:"metadata"`
+	Tags           []string          `json:"tags"`
+}
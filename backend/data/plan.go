@@ -0,0 +1,45 @@
+package data
+
+import "time"
+
+const (
+	PlanPolicyStop     = "stop"
+	PlanPolicyContinue = "continue"
+)
+
+// PlanDefinition is a named recovery plan: the VMs to recover together, the
+// order their dependencies impose and what to do when one of them fails.
+type PlanDefinition struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Policy      string     `json:"policy"`
+	Parallelism int        `json:"parallelism"`
+	VMs         []PlanVM   `json:"vms"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+}
+
+// PlanVM is one VM of a plan. It is recovered only after every VM in
+// DependsOn has been recovered and passed its wait condition; among VMs
+// that are ready at the same time, higher priorities start first.
+type PlanVM struct {
+	VMID      string         `json:"vm_id"`
+	Priority  int            `json:"priority"`
+	DependsOn []string       `json:"depends_on"`
+	Wait      *WaitCondition `json:"wait,omitempty"`
+}
+
+// WaitCondition holds back the dependents of a recovered VM for a fixed
+// delay and, with a port, until the port accepts TCP connections on the
+// fixed IP of every VM now hosting its workloads.
+type WaitCondition struct {
+	DelaySeconds   int `json:"delay_seconds"`
+	Port           int `json:"port"`
+	TimeoutSeconds int `json:"timeout_seconds"`
+}
+
+// PlanRun is the request stored on a plan job: the plan as it was when the
+// run started and the recovery options applied to each of its VMs.
+type PlanRun struct {
+	Plan PlanDefinition `json:"plan"`
+	RecoverRequest
+}
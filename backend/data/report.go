@@ -0,0 +1,60 @@
+package data
+
+import "time"
+
+// RecoveryTiming is the timeline of one recovery and the recovery time it
+// achieved, measured from detection, or from the request when the failure
+// was not reported earlier, to completion.
+type RecoveryTiming struct {
+	JobID       string       `json:"job_id"`
+	ParentJobID string       `json:"parent_job_id,omitempty"`
+	Plan        string       `json:"plan,omitempty"`
+	VMID        string       `json:"vm_id"`
+	VMName      string       `json:"vm_name"`
+	Status      string       `json:"status"`
+	DetectedAt  *time.Time   `json:"detected_at,omitempty"`
+	RequestedAt time.Time    `json:"requested_at"`
+	DecidedAt   *time.Time   `json:"decided_at,omitempty"`
+	StartedAt   *time.Time   `json:"started_at,omitempty"`
+	Steps       []StepTiming `json:"steps"`
+	ValidatedAt *time.Time   `json:"validated_at,omitempty"`
+	CompletedAt *time.Time   `json:"completed_at,omitempty"`
+
+	// RTOSeconds is only set for recoveries that succeeded or degraded.
+	RTOSeconds       *float64 `json:"rto_seconds,omitempty"`
+	TargetRTOSeconds int      `json:"target_rto_seconds,omitempty"`
+	Met              *bool    `json:"met,omitempty"`
+}
+
+type StepTiming struct {
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// PlanTiming is the recovery time of a whole plan run or bulk recovery,
+// measured from the earliest detection among its VMs, and compared against
+// the largest target RTO among them.
+type PlanTiming struct {
+	JobID            string     `json:"job_id"`
+	Kind             string     `json:"kind"`
+	Plan             string     `json:"plan,omitempty"`
+	Status           string     `json:"status"`
+	DetectedAt       *time.Time `json:"detected_at,omitempty"`
+	RequestedAt      time.Time  `json:"requested_at"`
+	CompletedAt      *time.Time `json:"completed_at,omitempty"`
+	RTOSeconds       *float64   `json:"rto_seconds,omitempty"`
+	TargetRTOSeconds int        `json:"target_rto_seconds,omitempty"`
+	Met              *bool      `json:"met,omitempty"`
+	Recoveries       int        `json:"recoveries"`
+}
+
+type RTOReport struct {
+	Recoveries []RecoveryTiming `json:"recoveries"`
+	Plans      []PlanTiming     `json:"plans"`
+}
+
+type TargetRTORequest struct {
+	TargetRTOSeconds int `json:"target_rto_seconds"`
+}
@@ -0,0 +1,32 @@
+package data
+
+const (
+	ProbeTCP  = "tcp"
+	ProbeHTTP = "http"
+)
+
+// ValidationConfig declares how the workloads of a VM are checked once it
+// has been recovered, on top of the server being ACTIVE with every
+// expected volume attached.
+type ValidationConfig struct {
+	Probes         []Probe `json:"probes"`
+	TimeoutSeconds int     `json:"timeout_seconds"`
+}
+
+// Probe connects to a port on the fixed IP of each server hosting the
+// recovered workloads. HTTP probes also GET Path and expect ExpectStatus,
+// or any 2xx or 3xx status when it is not set.
+type Probe struct {
+	Type         string `json:"type"`
+	Port         int    `json:"port"`
+	Path         string `json:"path,omitempty"`
+	ExpectStatus int    `json:"expect_status,omitempty"`
+}
+
+type ValidationResult struct {
+	ServerID string `json:"server_id"`
+	Server   string `json:"server"`
+	Check    string `json:"check"`
+	Passed   bool   `json:"passed"`
+	Detail   string `json:"detail,omitempty"`
+}
@@ -0,0 +1,21 @@
+package metrics
+
+import "github.com/jaehanbyun/VM-Disaster-Recovery/data"
+
+// Source provides recent resource utilisation for VMs keyed by server ID.
+// VMs without data are left out of the result.
+type Source interface {
+	Usage(ids []string) (map[string]data.Usage, error)
+}
+
+// NewSource returns the Prometheus source when a URL is configured, the
+// static file source when a file is configured, and nil otherwise.
+func NewSource(prometheusURL string, file string) Source {
+	if prometheusURL != "" {
+		return NewPrometheusSource(prometheusURL)
+	}
+	if file != "" {
+		return NewStaticSource(file)
+	}
+	return nil
+}
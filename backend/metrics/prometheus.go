@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+// Default queries for the libvirt exporter, each returning one sample per
+// domain labelled with the Nova server ID.
+const (
+	defaultIDLabel     = "uuid"
+	defaultCPUQuery    = `sum by (uuid) (rate(libvirt_domain_info_cpu_time_seconds_total[5m])) / sum by (uuid) (libvirt_domain_info_virtual_cpus)`
+	defaultMemoryQuery = `1 - sum by (uuid) (libvirt_domain_memory_stats_usable_bytes) / sum by (uuid) (libvirt_domain_memory_stats_available_bytes)`
+	defaultDiskIOQuery = `max by (uuid) (rate(libvirt_domain_block_stats_io_time_seconds_total[5m]))`
+)
+
+// queryTimeout bounds each query, so a hung Prometheus cannot stall the
+// planner that asked for usage.
+const queryTimeout = 10 * time.Second
+
+// PrometheusSource queries the Prometheus HTTP API for VM utilisation.
+type PrometheusSource struct {
+	URL         string
+	IDLabel     string
+	CPUQuery    string
+	MemoryQuery string
+	DiskIOQuery string
+
+	client *http.Client
+}
+
+func NewPrometheusSource(url string) *PrometheusSource {
+	return &PrometheusSource{
+		URL:         url,
+		client:      &http.Client{Timeout: queryTimeout},
+		IDLabel:     defaultIDLabel,
+		CPUQuery:    defaultCPUQuery,
+		MemoryQuery: defaultMemoryQuery,
+		DiskIOQuery: defaultDiskIOQuery,
+	}
+}
+
+type queryResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+func (p *PrometheusSource) Usage(ids []string) (map[string]data.Usage, error) {
+	cpu, err := p.query(p.CPUQuery)
+	if err != nil {
+		return nil, err
+	}
+	memory, err := p.query(p.MemoryQuery)
+	if err != nil {
+		return nil, err
+	}
+	diskIO, err := p.query(p.DiskIOQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make(map[string]data.Usage)
+	for _, id := range ids {
+		c, hasCPU := cpu[id]
+		m, hasMemory := memory[id]
+		d, hasDiskIO := diskIO[id]
+		if !hasCPU && !hasMemory && !hasDiskIO {
+			continue
+		}
+		usage[id] = data.Usage{CPU: c, Memory: m, DiskIO: d}
+	}
+
+	return usage, nil
+}
+
+// query runs an instant query and returns the sample value per server ID.
+func (p *PrometheusSource) query(q string) (map[string]float64, error) {
+	resp, err := p.client.Get(p.URL + "/api/v1/query?query=" + url.QueryEscape(q))
+	if err != nil {
+		return nil, fmt.Errorf("error querying prometheus: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	var queryResp queryResponse
+	if err := json.Unmarshal(body, &queryResp); err != nil {
+		return nil, fmt.Errorf("error unmarshaling JSON: %v", err)
+	}
+	if queryResp.Status != "success" {
+		return nil, fmt.Errorf("prometheus query failed: %s", queryResp.Error)
+	}
+
+	values := make(map[string]float64)
+	for _, sample := range queryResp.Data.Result {
+		id := sample.Metric[p.IDLabel]
+		if id == "" || len(sample.Value) != 2 {
+			continue
+		}
+		str, ok := sample.Value[1].(string)
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			continue
+		}
+		values[id] = v
+	}
+
+	return values, nil
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestPrometheusQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    map[string]float64
+		wantErr bool
+	}{
+		{
+			name: "one sample per server",
+			body: `{"status":"success","data":{"resultType":"vector","result":[
+				{"metric":{"uuid":"vm-1"},"value":[1700000000,"0.25"]},
+				{"metric":{"uuid":"vm-2"},"value":[1700000000,"1"]}]}}`,
+			want: map[string]float64{"vm-1": 0.25, "vm-2": 1},
+		},
+		{
+			name: "samples without the ID label are skipped",
+			body: `{"status":"success","data":{"resultType":"vector","result":[
+				{"metric":{"instance":"h1"},"value":[1700000000,"0.5"]},
+				{"metric":{"uuid":"vm-1"},"value":[1700000000,"0.75"]}]}}`,
+			want: map[string]float64{"vm-1": 0.75},
+		},
+		{
+			name: "non-string and unparsable values are skipped",
+			body: `{"status":"success","data":{"resultType":"vector","result":[
+				{"metric":{"uuid":"vm-1"},"value":[1700000000,0.5]},
+				{"metric":{"uuid":"vm-2"},"value":[1700000000,"NaN?"]},
+				{"metric":{"uuid":"vm-3"},"value":[1700000000]}]}}`,
+			want: map[string]float64{},
+		},
+		{
+			name:    "error status",
+			status:  http.StatusBadRequest,
+			body:    `{"status":"error","errorType":"bad_data","error":"parse error"}`,
+			wantErr: true,
+		},
+		{
+			name:    "not JSON",
+			status:  http.StatusBadGateway,
+			body:    `<html>bad gateway</html>`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v1/query" || r.URL.Query().Get("query") != "up" {
+					t.Errorf("unexpected request %s", r.URL)
+				}
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			got, err := NewPrometheusSource(server.URL).query("up")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("query() returned no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("query() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("query() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+// StaticSource reads utilisation from a JSON file mapping server IDs to
+// usage. The file is read on every call so it can be edited while running.
+type StaticSource struct {
+	Path string
+}
+
+func NewStaticSource(path string) *StaticSource {
+	return &StaticSource{Path: path}
+}
+
+func (s *StaticSource) Usage(ids []string) (map[string]data.Usage, error) {
+	body, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading metrics file: %v", err)
+	}
+
+	var all map[string]data.Usage
+	if err := json.Unmarshal(body, &all); err != nil {
+		return nil, fmt.Errorf("error unmarshaling metrics file: %v", err)
+	}
+
+	usage := make(map[string]data.Usage)
+	for _, id := range ids {
+		if u, ok := all[id]; ok {
+			usage[id] = u
+		}
+	}
+
+	return usage, nil
+}
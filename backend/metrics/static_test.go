@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+func TestStaticUsage(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		ids     []string
+		want    map[string]data.Usage
+		wantErr bool
+	}{
+		{
+			name: "only the requested servers",
+			file: `{"vm-1":{"cpu":0.5,"memory":0.25,"disk_io":0.1},"vm-2":{"cpu":1}}`,
+			ids:  []string{"vm-1", "vm-3"},
+			want: map[string]data.Usage{"vm-1": {CPU: 0.5, Memory: 0.25, DiskIO: 0.1}},
+		},
+		{
+			name: "no servers",
+			file: `{"vm-1":{"cpu":0.5}}`,
+			want: map[string]data.Usage{},
+		},
+		{
+			name:    "not JSON",
+			file:    `cpu=0.5`,
+			ids:     []string{"vm-1"},
+			wantErr: true,
+		},
+		{
+			name:    "missing file",
+			ids:     []string{"vm-1"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "usage.json")
+			if tt.file != "" {
+				if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := NewStaticSource(path).Usage(tt.ids)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Usage() returned no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Usage() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Usage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
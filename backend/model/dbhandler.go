@@ -44,7 +44,7 @@ func (p *postgresHandler) Init() error {
 		return fmt.Errorf("error unmarshalling response: %v", err)
 	}
 
-	statement, err := p.db.Prepare("INSERT INTO osinfo (id, name) VALUES ($1, $2)")
+	statement, err := p.db.Prepare("INSERT INTO osinfo (id, name) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name")
 	if err != nil {
 		return fmt.Errorf("error preparing statement: %v", err)
 	}
@@ -66,6 +66,7 @@ func (p *postgresHandler) Init() error {
 		Database:  1,
 		Webserver: 1,
 		Threshold: 0.8,
+		Load:      0.3,
 	}
 	err = p.SetWeight(*weight)
 	if err != nil {
@@ -76,9 +77,9 @@ func (p *postgresHandler) Init() error {
 }
 
 func (p *postgresHandler) GetWeight() (data.Weight, error) {
-	row := p.db.QueryRow("SELECT language, database, webserver, threshold FROM weight WHERE id = 1")
+	row := p.db.QueryRow("SELECT language, database, webserver, threshold, COALESCE(load, 0) FROM weight WHERE id = 1")
 	var weight data.Weight
-	err := row.Scan(&weight.Language, &weight.Database, &weight.Webserver, &weight.Threshold)
+	err := row.Scan(&weight.Language, &weight.Database, &weight.Webserver, &weight.Threshold, &weight.Load)
 	if err != nil {
 		return weight, err
 	}
@@ -86,14 +87,15 @@ func (p *postgresHandler) GetWeight() (data.Weight, error) {
 }
 
 func (p *postgresHandler) SetWeight(weight data.Weight) error {
-	_, err := p.db.Exec(`INSERT INTO weight (id, language, database, webserver, threshold) 
-                         VALUES (1, $1, $2, $3, $4) 
+	_, err := p.db.Exec(`INSERT INTO weight (id, language, database, webserver, threshold, load) 
+                         VALUES (1, $1, $2, $3, $4, $5) 
                          ON CONFLICT (id)
                          DO UPDATE SET language = EXCLUDED.language, 
                                        database = EXCLUDED.database, 
                                        webserver = EXCLUDED.webserver, 
-                                       threshold = EXCLUDED.threshold`,
-		weight.Language, weight.Database, weight.Webserver, weight.Threshold)
+                                       threshold = EXCLUDED.threshold,
+                                       load = EXCLUDED.load`,
+		weight.Language, weight.Database, weight.Webserver, weight.Threshold, weight.Load)
 	return err
 }
 
@@ -112,18 +114,37 @@ func (p *postgresHandler) SetThreshold(t float32) error {
 	return err
 }
 
-func (p *postgresHandler) GetVMInfo(id string) (*data.VMInstance, error) {
-	row := p.db.QueryRow("SELECT id, name, os, language, database, webserver FROM vminfo WHERE id = $1", id)
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+const vmInfoColumns = "id, name, COALESCE(flavorid, ''), COALESCE(os, ''), language, database, webserver, COALESCE(strategy, ''), " +
+	"COALESCE(host, ''), COALESCE(az, ''), COALESCE(servergroups, '[]'), COALESCE(status, ''), COALESCE(target_rto, 0), " +
+	"COALESCE(networks, '[]'), COALESCE(attributes, '{}'), COALESCE(root_volume, '')"
 
+func scanVMInstance(row rowScanner) (*data.VMInstance, error) {
 	var vm data.VMInstance
-	var languagesStr, databasesStr, webserversStr string
+	var languagesStr, databasesStr, webserversStr, serverGroupsStr, networksStr, attributesStr string
 
-	err := row.Scan(&vm.ID, &vm.Name, &vm.OS, &languagesStr, &databasesStr, &webserversStr)
+	err := row.Scan(&vm.ID, &vm.Name, &vm.FlavorID, &vm.OS, &languagesStr, &databasesStr, &webserversStr, &vm.Strategy,
+		&vm.Host, &vm.AvailabilityZone, &serverGroupsStr, &vm.Status, &vm.TargetRTO, &networksStr, &attributesStr, &vm.RootVolume)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("no VM instance found with ID: %s", id)
-		}
-		return nil, fmt.Errorf("error scanning database row: %v", err)
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(serverGroupsStr), &vm.ServerGroups)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling server groups data: %v", err)
+	}
+
+	err = json.Unmarshal([]byte(networksStr), &vm.Networks)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling networks data: %v", err)
+	}
+
+	err = json.Unmarshal([]byte(attributesStr), &vm.Attributes)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling attributes data: %v", err)
 	}
 
 	err = json.Unmarshal([]byte(languagesStr), &vm.Software.Languages)
@@ -144,8 +165,40 @@ func (p *postgresHandler) GetVMInfo(id string) (*data.VMInstance, error) {
 	return &vm, nil
 }
 
+func (p *postgresHandler) GetSetting(key string) (string, error) {
+	row := p.db.QueryRow("SELECT value FROM settings WHERE key = $1", key)
+	var value string
+	err := row.Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("error scanning settings: %v", err)
+	}
+	return value, nil
+}
+
+func (p *postgresHandler) SetSetting(key string, value string) error {
+	_, err := p.db.Exec(`INSERT INTO settings (key, value) VALUES ($1, $2)
+                         ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value`, key, value)
+	return err
+}
+
+func (p *postgresHandler) GetVMInfo(id string) (*data.VMInstance, error) {
+	row := p.db.QueryRow("SELECT "+vmInfoColumns+" FROM vminfo WHERE id = $1", id)
+
+	vm, err := scanVMInstance(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no VM instance found with ID: %s", id)
+		}
+		return nil, fmt.Errorf("error scanning database row: %v", err)
+	}
+
+	return vm, nil
+}
+
 func (p *postgresHandler) GetVMsInfo() ([]*data.VMInstance, error) {
-	rows, err := p.db.Query("SELECT id, name, flavorid, os, language, database, webserver FROM vminfo")
+	rows, err := p.db.Query("SELECT " + vmInfoColumns + " FROM vminfo")
 	if err != nil {
 		return nil, fmt.Errorf("error querying vminfo: %v", err)
 	}
@@ -154,29 +207,12 @@ func (p *postgresHandler) GetVMsInfo() ([]*data.VMInstance, error) {
 	var vmInstances []*data.VMInstance
 
 	for rows.Next() {
-		var vm data.VMInstance
-		var languagesStr, databasesStr, webserversStr string
-
-		if err := rows.Scan(&vm.ID, &vm.Name, &vm.FlavorID, &vm.OS, &languagesStr, &databasesStr, &webserversStr); err != nil {
-			return nil, fmt.Errorf("error scanning databases: %v", err)
-		}
-
-		err = json.Unmarshal([]byte(languagesStr), &vm.Software.Languages)
+		vm, err := scanVMInstance(rows)
 		if err != nil {
-			return nil, fmt.Errorf("error unmarshaling languages data: %v", err)
-		}
-
-		err = json.Unmarshal([]byte(databasesStr), &vm.Software.Databases)
-		if err != nil {
-			return nil, fmt.Errorf("error unmarshaling databases data: %v", err)
-		}
-
-		err = json.Unmarshal([]byte(webserversStr), &vm.Software.Webservers)
-		if err != nil {
-			return nil, fmt.Errorf("error unmarshaling webservers data: %v", err)
+			return nil, fmt.Errorf("error scanning databases: %v", err)
 		}
 
-		vmInstances = append(vmInstances, &vm)
+		vmInstances = append(vmInstances, vm)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -186,59 +222,106 @@ func (p *postgresHandler) GetVMsInfo() ([]*data.VMInstance, error) {
 	return vmInstances, nil
 }
 
-func (p *postgresHandler) SetVMInfo(v data.VMInstance) error {
-	languageText, err := json.Marshal(v.Software.Languages)
+func (p *postgresHandler) SetVMStrategy(id string, strategy string) error {
+	result, err := p.db.Exec("UPDATE vminfo SET strategy = NULLIF($2, '') WHERE id = $1", id, strategy)
 	if err != nil {
-		return err
+		return fmt.Errorf("error updating strategy: %v", err)
 	}
 
-	databaseText, err := json.Marshal(v.Software.Databases)
+	n, err := result.RowsAffected()
 	if err != nil {
-		return err
+		return fmt.Errorf("error reading affected rows: %v", err)
 	}
+	if n == 0 {
+		return fmt.Errorf("no VM instance found with ID: %s", id)
+	}
+
+	return nil
+}
 
-	webserverText, err := json.Marshal(v.Software.Webservers)
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// upsertVM writes every inventory column of v except its similarity strategy.
+func upsertVM(ex execer, v data.VMInstance) error {
+	languagesJSON, _ := json.Marshal(v.Software.Languages)
+	databasesJSON, _ := json.Marshal(v.Software.Databases)
+	webserversJSON, _ := json.Marshal(v.Software.Webservers)
+	serverGroupsJSON, _ := json.Marshal(v.ServerGroups)
+	networksJSON, _ := json.Marshal(v.Networks)
+	attributesJSON, _ := json.Marshal(v.Attributes)
+
+	_, err := ex.Exec(`INSERT INTO vminfo (id, name, flavorid, os, language, database, webserver, host, az, servergroups, status, target_rto,
+                                          networks, attributes, root_volume)
+                       VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, ''), NULLIF($12, 0), $13, $14, NULLIF($15, ''))
+                       ON CONFLICT (id)
+                       DO UPDATE SET name = EXCLUDED.name,
+                                     flavorid = EXCLUDED.flavorid,
+                                     os = EXCLUDED.os,
+                                     language = EXCLUDED.language,
+                                     database = EXCLUDED.database,
+                                     webserver = EXCLUDED.webserver,
+                                     host = EXCLUDED.host,
+                                     az = EXCLUDED.az,
+                                     servergroups = EXCLUDED.servergroups,
+                                     status = EXCLUDED.status,
+                                     target_rto = EXCLUDED.target_rto,
+                                     networks = EXCLUDED.networks,
+                                     attributes = EXCLUDED.attributes,
+                                     root_volume = EXCLUDED.root_volume`,
+		v.ID, v.Name, v.FlavorID, v.OS, languagesJSON, databasesJSON, webserversJSON, v.Host, v.AvailabilityZone, serverGroupsJSON, v.Status,
+		v.TargetRTO, networksJSON, attributesJSON, v.RootVolume)
 	if err != nil {
-		return err
+		return fmt.Errorf("error upserting VM record: %v", err)
 	}
+	return nil
+}
 
-	statement, err := p.db.Prepare("INSERT INTO vminfo (id, language, database, webserver) VALUES ($1, $2, $3, $4, $5")
+func (p *postgresHandler) SetVMInfo(v data.VMInstance) error {
+	return upsertVM(p.db, v)
+}
+
+func (p *postgresHandler) SetVMTargetRTO(id string, seconds int) error {
+	result, err := p.db.Exec("UPDATE vminfo SET target_rto = NULLIF($2, 0) WHERE id = $1", id, seconds)
 	if err != nil {
-		return err
+		return fmt.Errorf("error updating target RTO: %v", err)
 	}
-	defer statement.Close()
 
-	_, err = statement.Exec(v.ID, languageText, databaseText, webserverText)
+	n, err := result.RowsAffected()
 	if err != nil {
-		return err
+		return fmt.Errorf("error reading affected rows: %v", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("no VM instance found with ID: %s", id)
 	}
 
 	return nil
 }
 
-func (p *postgresHandler) SetVMsInfo() error {
-	token := common.GetToken()
-	req, err := http.NewRequest("GET", common.BaseOpenstackUrl+"/compute/v2.1/servers/detail", nil)
+func (p *postgresHandler) SetVMStatus(id string, status string) error {
+	_, err := p.db.Exec("UPDATE vminfo SET status = NULLIF($2, '') WHERE id = $1", id, status)
 	if err != nil {
-		return fmt.Errorf("error creating request: %s", err)
+		return fmt.Errorf("error setting VM status: %v", err)
 	}
-	req.Header.Set("X-Auth-Token", token)
-	req.Header.Set("content-type", "application/json")
+	return nil
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+func (p *postgresHandler) SetVMsInfo() error {
+	token := common.GetToken()
+	servers, err := common.GetServers(token)
 	if err != nil {
 		return fmt.Errorf("error fetching instance info: %v", err)
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-
-	var openStackResp data.OpenStackResponse
-	json.Unmarshal(body, &openStackResp)
 
 	var vms []data.VMInstance
-	for _, server := range openStackResp.Servers {
+	for _, server := range servers {
+		// Only the single server view reports server group membership.
+		detail, err := common.GetServer(token, server.ID)
+		if err != nil {
+			return fmt.Errorf("error fetching server groups: %v", err)
+		}
+
 		serverName := server.Name
 		flavorID := server.Flavor.ID
 		volumeIDs := server.OsExtendedVolumesVolumesAttached
@@ -247,16 +330,53 @@ func (p *postgresHandler) SetVMsInfo() error {
 			return fmt.Errorf("error getting os name: %s", err)
 		}
 
+		// A boot-from-volume server has no image; its OS is the image its
+		// root volume was created from.
+		root, err := common.RootVolume(token, server)
+		if err != nil {
+			return fmt.Errorf("error finding root volume: %s", err)
+		}
+		if root.ID != "" {
+			os, err = p.GetImageName(root.ImageMeta["image_id"])
+			if err != nil {
+				return fmt.Errorf("error getting os name: %s", err)
+			}
+			if os == "" {
+				os = common.VolumeImageName(root)
+			}
+		}
+
 		var languages, databases, webservers []data.Volume
 		for _, volumeID := range volumeIDs {
-			metadata, err := common.GetVolumeMetadata(token, volumeID.ID)
+			if volumeID.ID == root.ID {
+				continue
+			}
+			volume, err := common.GetVolume(token, volumeID.ID)
 			if err != nil {
 				return fmt.Errorf(fmt.Sprintf("error fetching volume metadata: %s", err))
 			}
+			metadata := volume.Metadata
 			vol := data.Volume{
 				ID:      volumeID.ID,
 				Content: metadata.Content,
 			}
+			for _, attachment := range volume.Attachments {
+				if attachment.ServerID == server.ID {
+					vol.Device = attachment.Device
+				}
+			}
+			if metadata.Mountpoint != "" {
+				mount := &data.MountInfo{
+					Filesystem: metadata.Filesystem,
+					Mountpoint: metadata.Mountpoint,
+					Service:    metadata.Service,
+				}
+				if err := mount.Validate(); err != nil {
+					fmt.Printf("Ignoring mount info of volume %s: %s\n", volumeID.ID, err)
+				} else {
+					vol.Mount = mount
+				}
+			}
 			switch metadata.Type {
 			case "database":
 				databases = append(databases, vol)
@@ -277,21 +397,53 @@ func (p *postgresHandler) SetVMsInfo() error {
 				Databases:  databases,
 				Webservers: webservers,
 			},
+			Host:             server.Host,
+			AvailabilityZone: server.AvailabilityZone,
+			ServerGroups:     detail.ServerGroups,
+			Attributes:       common.Attributes(server),
+			RootVolume:       root.ID,
+		}
+
+		// The inventory is still usable without networks; a recreation
+		// then falls back to Nova's automatic network allocation.
+		vm.Networks, err = common.GetServerNetworks(token, server.ID)
+		if err != nil {
+			fmt.Printf("Error fetching networks of %s: %s\n", server.Name, err)
 		}
 		vms = append(vms, vm)
 	}
 
-	statement, err := p.db.Prepare("INSERT INTO vminfo (id, name, flavorid, os, language, database, webserver) VALUES ($1, $2, $3, $4, $5, $6)")
+	statement, err := p.db.Prepare(`INSERT INTO vminfo (id, name, flavorid, os, language, database, webserver, host, az, servergroups, networks,
+                                                        attributes, root_volume)
+                                    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13, ''))
+                                    ON CONFLICT (id)
+                                    DO UPDATE SET name = EXCLUDED.name,
+                                                  flavorid = EXCLUDED.flavorid,
+                                                  os = EXCLUDED.os,
+                                                  language = EXCLUDED.language,
+                                                  database = EXCLUDED.database,
+                                                  webserver = EXCLUDED.webserver,
+                                                  host = EXCLUDED.host,
+                                                  az = EXCLUDED.az,
+                                                  servergroups = EXCLUDED.servergroups,
+                                                  networks = EXCLUDED.networks,
+                                                  attributes = EXCLUDED.attributes,
+                                                  root_volume = EXCLUDED.root_volume`)
 	if err != nil {
 		return fmt.Errorf("error preparing statement: %v", err)
 	}
+	defer statement.Close()
 
 	for _, vm := range vms {
 		languagesJSON, _ := json.Marshal(vm.Software.Languages)
 		databasesJSON, _ := json.Marshal(vm.Software.Databases)
 		webserversJSON, _ := json.Marshal(vm.Software.Webservers)
+		serverGroupsJSON, _ := json.Marshal(vm.ServerGroups)
+		networksJSON, _ := json.Marshal(vm.Networks)
+		attributesJSON, _ := json.Marshal(vm.Attributes)
 
-		_, err := statement.Exec(vm.ID, vm.Name, vm.FlavorID, vm.OS, languagesJSON, databasesJSON, webserversJSON)
+		_, err := statement.Exec(vm.ID, vm.Name, vm.FlavorID, vm.OS, languagesJSON, databasesJSON, webserversJSON,
+			vm.Host, vm.AvailabilityZone, serverGroupsJSON, networksJSON, attributesJSON, vm.RootVolume)
 		if err != nil {
 			return fmt.Errorf("error inserting VM record: %v", err)
 		}
@@ -312,6 +464,19 @@ func (p *postgresHandler) GetImageName(id string) (string, error) {
 	return osName, nil
 }
 
+// GetImageID returns the ID of the image with the given name.
+func (p *postgresHandler) GetImageID(name string) (string, error) {
+	row := p.db.QueryRow("SELECT id FROM osinfo WHERE name = $1 LIMIT 1", name)
+	var id string
+	err := row.Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("no image named %s", name)
+	} else if err != nil {
+		return "", fmt.Errorf("error scanning osinfo: %v", err)
+	}
+	return id, nil
+}
+
 func newPostgresHandler() DBHandler {
 	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
 		"localhost", 5432, "postgres", "postgres", "vms",
@@ -333,13 +498,18 @@ func newPostgresHandler() DBHandler {
 				language NUMERIC,
 				database NUMERIC,
 				webserver NUMERIC,
-				threshold NUMERIC
+				threshold NUMERIC,
+				load NUMERIC
 			);`)
 	_, err = createWeight.Exec()
 	if err != nil {
 		panic(err)
 	}
 
+	addColumns(database, "weight",
+		"load NUMERIC",
+	)
+
 	createVMInfo, _ := database.Prepare(
 		`CREATE TABLE IF NOT EXISTS vminfo (
 			id TEXT PRIMARY KEY,
@@ -348,13 +518,34 @@ func newPostgresHandler() DBHandler {
 			os TEXT,
 			language JSON,
 			database JSON,
-			webserver JSON
+			webserver JSON,
+			strategy TEXT,
+			host TEXT,
+			az TEXT,
+			servergroups JSON,
+			status TEXT,
+			target_rto INT,
+			networks JSON,
+			attributes JSON,
+			root_volume TEXT
 		);`)
 	_, err = createVMInfo.Exec()
 	if err != nil {
 		panic(err)
 	}
 
+	addColumns(database, "vminfo",
+		"strategy TEXT",
+		"host TEXT",
+		"az TEXT",
+		"servergroups JSON",
+		"status TEXT",
+		"target_rto INT",
+		"networks JSON",
+		"attributes JSON",
+		"root_volume TEXT",
+	)
+
 	createOSInfo, _ := database.Prepare(
 		`CREATE TABLE IF NOT EXISTS osinfo (
 			id TEXT PRIMARY KEY,
@@ -365,5 +556,160 @@ func newPostgresHandler() DBHandler {
 		panic(err)
 	}
 
+	createSettings, _ := database.Prepare(
+		`CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value TEXT
+		);`)
+	_, err = createSettings.Exec()
+	if err != nil {
+		panic(err)
+	}
+
+	createJobs, _ := database.Prepare(
+		`CREATE TABLE IF NOT EXISTS jobs (
+			id TEXT PRIMARY KEY,
+			kind TEXT NOT NULL,
+			parent_id TEXT,
+			owner TEXT,
+			vm_id TEXT,
+			status TEXT NOT NULL,
+			request JSON,
+			plan JSON,
+			failback JSON,
+			targets JSON,
+			steps JSON,
+			fencing JSON,
+			error TEXT,
+			rollback TEXT,
+			validation JSON,
+			approval JSON,
+			devices JSON,
+			created_at TIMESTAMPTZ NOT NULL,
+			started_at TIMESTAMPTZ,
+			finished_at TIMESTAMPTZ,
+			updated_at TIMESTAMPTZ NOT NULL,
+			heartbeat_at TIMESTAMPTZ
+		);`)
+	_, err = createJobs.Exec()
+	if err != nil {
+		panic(err)
+	}
+
+	addColumns(database, "jobs",
+		"parent_id TEXT",
+		"failback JSON",
+		"fencing JSON",
+		"rollback TEXT",
+		"validation JSON",
+		"approval JSON",
+		"devices JSON",
+		"owner TEXT",
+		"heartbeat_at TIMESTAMPTZ",
+	)
+
+	createRecoveryLocks, _ := database.Prepare(
+		`CREATE TABLE IF NOT EXISTS recovery_locks (
+			key TEXT PRIMARY KEY,
+			job_id TEXT NOT NULL,
+			acquired_at TIMESTAMPTZ NOT NULL
+		);`)
+	_, err = createRecoveryLocks.Exec()
+	if err != nil {
+		panic(err)
+	}
+
+	createIdempotencyKeys, _ := database.Prepare(
+		`CREATE TABLE IF NOT EXISTS idempotency_keys (
+			key TEXT PRIMARY KEY,
+			request_hash TEXT NOT NULL,
+			job_id TEXT,
+			status_code INT,
+			response JSON,
+			created_at TIMESTAMPTZ NOT NULL
+		);`)
+	_, err = createIdempotencyKeys.Exec()
+	if err != nil {
+		panic(err)
+	}
+
+	createRecoveryPlans, _ := database.Prepare(
+		`CREATE TABLE IF NOT EXISTS recovery_plans (
+			name TEXT PRIMARY KEY,
+			definition JSON NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL
+		);`)
+	_, err = createRecoveryPlans.Exec()
+	if err != nil {
+		panic(err)
+	}
+
+	createValidations, _ := database.Prepare(
+		`CREATE TABLE IF NOT EXISTS validations (
+			vm_id TEXT PRIMARY KEY,
+			config JSON NOT NULL
+		);`)
+	_, err = createValidations.Exec()
+	if err != nil {
+		panic(err)
+	}
+
+	createHealth, _ := database.Prepare(
+		`CREATE TABLE IF NOT EXISTS health (
+			vm_id TEXT PRIMARY KEY,
+			state TEXT NOT NULL,
+			health JSON NOT NULL,
+			checked_at TIMESTAMPTZ NOT NULL
+		);`)
+	_, err = createHealth.Exec()
+	if err != nil {
+		panic(err)
+	}
+
+	createLineage, _ := database.Prepare(
+		`CREATE TABLE IF NOT EXISTS lineage (
+			source_id TEXT NOT NULL,
+			replacement_id TEXT NOT NULL,
+			job_id TEXT,
+			kind TEXT NOT NULL,
+			volumes JSON,
+			created_at TIMESTAMPTZ NOT NULL,
+			failed_back_at TIMESTAMPTZ,
+			root_volume TEXT,
+			devices JSON
+		);`)
+	_, err = createLineage.Exec()
+	if err != nil {
+		panic(err)
+	}
+
+	createPlacements, _ := database.Prepare(
+		`CREATE TABLE IF NOT EXISTS placements (
+			domain TEXT PRIMARY KEY,
+			count INTEGER NOT NULL
+		);`)
+	_, err = createPlacements.Exec()
+	if err != nil {
+		panic(err)
+	}
+
+	addColumns(database, "lineage",
+		"failed_back_at TIMESTAMPTZ",
+		"root_volume TEXT",
+		"devices JSON",
+	)
+
 	return &postgresHandler{database}
 }
+
+// addColumns adds columns introduced after table was first created, so an
+// existing database picks them up; CREATE TABLE IF NOT EXISTS alone leaves
+// it untouched.
+func addColumns(database *sql.DB, table string, columns ...string) {
+	for _, column := range columns {
+		_, err := database.Exec("ALTER TABLE " + table + " ADD COLUMN IF NOT EXISTS " + column)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
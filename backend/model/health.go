@@ -0,0 +1,80 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+// ErrHealthNotFound is returned for a VM the failure monitor has not checked yet.
+var ErrHealthNotFound = errors.New("no health recorded for VM")
+
+func (p *postgresHandler) GetHealth(vmID string) (data.VMHealth, error) {
+	row := p.db.QueryRow("SELECT health FROM health WHERE vm_id = $1", vmID)
+	var healthStr string
+	err := row.Scan(&healthStr)
+	if err == sql.ErrNoRows {
+		return data.VMHealth{}, ErrHealthNotFound
+	} else if err != nil {
+		return data.VMHealth{}, fmt.Errorf("error scanning health: %v", err)
+	}
+
+	var health data.VMHealth
+	err = json.Unmarshal([]byte(healthStr), &health)
+	if err != nil {
+		return data.VMHealth{}, fmt.Errorf("error unmarshaling health: %v", err)
+	}
+	return health, nil
+}
+
+func (p *postgresHandler) GetHealths() ([]data.VMHealth, error) {
+	rows, err := p.db.Query("SELECT health FROM health ORDER BY vm_id")
+	if err != nil {
+		return nil, fmt.Errorf("error querying health: %v", err)
+	}
+	defer rows.Close()
+
+	healths := []data.VMHealth{}
+	for rows.Next() {
+		var healthStr string
+		err := rows.Scan(&healthStr)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning health: %v", err)
+		}
+		var health data.VMHealth
+		err = json.Unmarshal([]byte(healthStr), &health)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling health: %v", err)
+		}
+		healths = append(healths, health)
+	}
+	return healths, rows.Err()
+}
+
+func (p *postgresHandler) SetHealth(health data.VMHealth) error {
+	healthJSON, err := json.Marshal(health)
+	if err != nil {
+		return fmt.Errorf("error marshaling health: %v", err)
+	}
+
+	_, err = p.db.Exec(`INSERT INTO health (vm_id, state, health, checked_at) VALUES ($1, $2, $3, $4)
+                        ON CONFLICT (vm_id) DO UPDATE SET state = EXCLUDED.state, health = EXCLUDED.health,
+                                                          checked_at = EXCLUDED.checked_at`,
+		health.VMID, health.State, healthJSON, health.CheckedAt)
+	if err != nil {
+		return fmt.Errorf("error storing health: %v", err)
+	}
+	return nil
+}
+
+// DeleteHealth forgets the health of a VM the monitor no longer watches.
+func (p *postgresHandler) DeleteHealth(vmID string) error {
+	_, err := p.db.Exec("DELETE FROM health WHERE vm_id = $1", vmID)
+	if err != nil {
+		return fmt.Errorf("error deleting health: %v", err)
+	}
+	return nil
+}
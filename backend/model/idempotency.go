@@ -0,0 +1,67 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+// ReserveIdempotencyKey claims key for a request with the given hash. It
+// returns nil when the key is new and the request should run, or the
+// existing record when the key was used before. A reservation made before
+// staleBefore that never completed belongs to a request that died, and is
+// taken over.
+func (p *postgresHandler) ReserveIdempotencyKey(key string, requestHash string, staleBefore time.Time) (*data.IdempotencyRecord, error) {
+	result, err := p.db.Exec(`INSERT INTO idempotency_keys (key, request_hash, created_at) VALUES ($1, $2, $3)
+                              ON CONFLICT (key) DO UPDATE SET request_hash = EXCLUDED.request_hash, created_at = EXCLUDED.created_at
+                              WHERE idempotency_keys.status_code IS NULL AND idempotency_keys.created_at < $4`,
+		key, requestHash, time.Now().UTC(), staleBefore)
+	if err != nil {
+		return nil, fmt.Errorf("error reserving idempotency key: %v", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("error reading affected rows: %v", err)
+	}
+	if n == 1 {
+		return nil, nil
+	}
+
+	row := p.db.QueryRow(`SELECT key, request_hash, COALESCE(job_id, ''), COALESCE(status_code, 0), COALESCE(response, 'null'), created_at
+                          FROM idempotency_keys WHERE key = $1`, key)
+
+	var record data.IdempotencyRecord
+	var response string
+	err = row.Scan(&record.Key, &record.RequestHash, &record.JobID, &record.StatusCode, &response, &record.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("idempotency key %s was released concurrently, retry the request", key)
+	} else if err != nil {
+		return nil, fmt.Errorf("error scanning idempotency key: %v", err)
+	}
+	record.Response = []byte(response)
+
+	return &record, nil
+}
+
+// CompleteIdempotencyKey stores the response a key's request produced.
+func (p *postgresHandler) CompleteIdempotencyKey(key string, jobID string, statusCode int, response []byte) error {
+	_, err := p.db.Exec("UPDATE idempotency_keys SET job_id = $2, status_code = $3, response = $4 WHERE key = $1",
+		key, jobID, statusCode, response)
+	if err != nil {
+		return fmt.Errorf("error completing idempotency key: %v", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets a key whose request did not produce a job,
+// so it can be retried.
+func (p *postgresHandler) ReleaseIdempotencyKey(key string) error {
+	_, err := p.db.Exec("DELETE FROM idempotency_keys WHERE key = $1 AND status_code IS NULL", key)
+	if err != nil {
+		return fmt.Errorf("error releasing idempotency key: %v", err)
+	}
+	return nil
+}
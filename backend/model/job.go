@@ -0,0 +1,272 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+// ErrJobNotQueued is returned when a job to be claimed is no longer queued,
+// usually because another worker claimed it first.
+var ErrJobNotQueued = errors.New("job is not queued")
+
+// ErrJobStatusChanged is returned when a job to be updated no longer has
+// the status it was read with.
+var ErrJobStatusChanged = errors.New("job status changed")
+
+const jobColumns = "id, kind, COALESCE(parent_id, ''), COALESCE(owner, ''), COALESCE(vm_id, ''), status, COALESCE(request, 'null'), COALESCE(plan, 'null'), COALESCE(failback, 'null'), COALESCE(targets, '[]'), " +
+	"COALESCE(steps, '[]'), COALESCE(fencing, 'null'), COALESCE(error, ''), COALESCE(rollback, ''), COALESCE(validation, 'null'), COALESCE(approval, 'null'), COALESCE(devices, 'null'), created_at, started_at, finished_at, updated_at"
+
+func scanJob(row rowScanner) (*data.Job, error) {
+	var job data.Job
+	var requestStr, planStr, failbackStr, targetsStr, stepsStr, fencingStr, validationStr, approvalStr, devicesStr string
+	var startedAt, finishedAt sql.NullTime
+
+	err := row.Scan(&job.ID, &job.Kind, &job.ParentID, &job.Owner, &job.VMID, &job.Status, &requestStr, &planStr, &failbackStr, &targetsStr,
+		&stepsStr, &fencingStr, &job.Error, &job.Rollback, &validationStr, &approvalStr, &devicesStr, &job.CreatedAt, &startedAt, &finishedAt, &job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if requestStr != "null" {
+		job.Request = json.RawMessage(requestStr)
+	}
+
+	err = json.Unmarshal([]byte(planStr), &job.Plan)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling plan data: %v", err)
+	}
+
+	err = json.Unmarshal([]byte(failbackStr), &job.Failback)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling failback data: %v", err)
+	}
+
+	err = json.Unmarshal([]byte(targetsStr), &job.Targets)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling targets data: %v", err)
+	}
+
+	err = json.Unmarshal([]byte(stepsStr), &job.Steps)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling steps data: %v", err)
+	}
+
+	err = json.Unmarshal([]byte(fencingStr), &job.Fencing)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling fencing data: %v", err)
+	}
+
+	err = json.Unmarshal([]byte(validationStr), &job.Validation)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling validation data: %v", err)
+	}
+
+	err = json.Unmarshal([]byte(approvalStr), &job.Approval)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling approval data: %v", err)
+	}
+
+	err = json.Unmarshal([]byte(devicesStr), &job.Devices)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling devices data: %v", err)
+	}
+
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+
+	return &job, nil
+}
+
+func (p *postgresHandler) CreateJob(job data.Job) error {
+	planJSON, _ := json.Marshal(job.Plan)
+	failbackJSON, _ := json.Marshal(job.Failback)
+	targetsJSON, _ := json.Marshal(job.Targets)
+	stepsJSON, _ := json.Marshal(job.Steps)
+	approvalJSON, _ := json.Marshal(job.Approval)
+
+	var request interface{}
+	if len(job.Request) > 0 {
+		request = []byte(job.Request)
+	}
+
+	_, err := p.db.Exec(`INSERT INTO jobs (id, kind, parent_id, vm_id, status, request, plan, failback, targets, steps, error, approval, created_at, updated_at)
+                         VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $13)`,
+		job.ID, job.Kind, job.ParentID, job.VMID, job.Status, request, planJSON, failbackJSON, targetsJSON, stepsJSON, job.Error, approvalJSON,
+		job.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting job: %v", err)
+	}
+	return nil
+}
+
+func (p *postgresHandler) UpdateJob(job data.Job) error {
+	_, err := p.updateJob(job, "")
+	return err
+}
+
+// UpdateJobIf updates job only while it still has status in the database,
+// so of two concurrent decisions on a job only the first takes effect. The
+// other gets ErrJobStatusChanged.
+func (p *postgresHandler) UpdateJobIf(job data.Job, status string) error {
+	updated, err := p.updateJob(job, status)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrJobStatusChanged
+	}
+	return nil
+}
+
+// updateJob writes job, only while it has status when status is set, and
+// reports whether it was updated.
+func (p *postgresHandler) updateJob(job data.Job, status string) (bool, error) {
+	planJSON, _ := json.Marshal(job.Plan)
+	targetsJSON, _ := json.Marshal(job.Targets)
+	stepsJSON, _ := json.Marshal(job.Steps)
+	fencingJSON, _ := json.Marshal(job.Fencing)
+	validationJSON, _ := json.Marshal(job.Validation)
+	approvalJSON, _ := json.Marshal(job.Approval)
+	devicesJSON, _ := json.Marshal(job.Devices)
+
+	result, err := p.db.Exec(`UPDATE jobs SET status = $2, plan = $3, targets = $4, steps = $5, error = $6, rollback = $7,
+                                              started_at = $8, finished_at = $9, updated_at = $10, fencing = $11, validation = $12,
+                                              approval = $13, devices = $14
+                              WHERE id = $1 AND ($15 = '' OR status = $15)`,
+		job.ID, job.Status, planJSON, targetsJSON, stepsJSON, job.Error, job.Rollback, job.StartedAt, job.FinishedAt, time.Now().UTC(),
+		fencingJSON, validationJSON, approvalJSON, devicesJSON, status)
+	if err != nil {
+		return false, fmt.Errorf("error updating job: %v", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error updating job: %v", err)
+	}
+	return rows > 0, nil
+}
+
+// ClaimJob marks a queued job running on behalf of owner. Only one caller
+// can claim a job; the others get ErrJobNotQueued.
+func (p *postgresHandler) ClaimJob(id string, owner string) (*data.Job, error) {
+	now := time.Now().UTC()
+	row := p.db.QueryRow(`UPDATE jobs SET status = $2, owner = $3, started_at = $4, heartbeat_at = $4, updated_at = $4
+                          WHERE id = $1 AND status = $5
+                          RETURNING `+jobColumns,
+		id, data.JobRunning, owner, now, data.JobQueued)
+
+	job, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrJobNotQueued
+	} else if err != nil {
+		return nil, fmt.Errorf("error claiming job: %v", err)
+	}
+	return job, nil
+}
+
+// HeartbeatJobs records that owner is still running its jobs.
+func (p *postgresHandler) HeartbeatJobs(owner string) error {
+	_, err := p.db.Exec("UPDATE jobs SET heartbeat_at = $2 WHERE owner = $1 AND status = $3", owner, time.Now().UTC(), data.JobRunning)
+	if err != nil {
+		return fmt.Errorf("error updating job heartbeat: %v", err)
+	}
+	return nil
+}
+
+// InterruptStaleJobs marks running jobs without a heartbeat since before as
+// interrupted, together with their children that had not started, and
+// returns the IDs of the running jobs it interrupted.
+func (p *postgresHandler) InterruptStaleJobs(before time.Time, reason string) ([]string, error) {
+	now := time.Now().UTC()
+	rows, err := p.db.Query(`UPDATE jobs SET status = $1, error = $2, finished_at = $3, updated_at = $3
+                             WHERE status = $4 AND (heartbeat_at IS NULL OR heartbeat_at < $5)
+                             RETURNING id`,
+		data.JobInterrupted, reason, now, data.JobRunning, before)
+	if err != nil {
+		return nil, fmt.Errorf("error interrupting jobs: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning jobs: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		_, err := p.db.Exec(`UPDATE jobs SET status = $1, error = $2, finished_at = $3, updated_at = $3
+                             WHERE parent_id = $4 AND status = $5`,
+			data.JobInterrupted, reason, now, id, data.JobQueued)
+		if err != nil {
+			return ids, fmt.Errorf("error interrupting child jobs of %s: %v", id, err)
+		}
+	}
+	return ids, nil
+}
+
+func (p *postgresHandler) GetJob(id string) (*data.Job, error) {
+	row := p.db.QueryRow("SELECT "+jobColumns+" FROM jobs WHERE id = $1", id)
+
+	job, err := scanJob(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no job found with ID: %s", id)
+		}
+		return nil, fmt.Errorf("error scanning database row: %v", err)
+	}
+
+	return job, nil
+}
+
+func (p *postgresHandler) GetJobs(limit int) ([]*data.Job, error) {
+	return p.queryJobs("SELECT "+jobColumns+" FROM jobs WHERE parent_id IS NULL ORDER BY created_at DESC LIMIT $1", limit)
+}
+
+func (p *postgresHandler) GetJobsByStatus(status string) ([]*data.Job, error) {
+	return p.queryJobs("SELECT "+jobColumns+" FROM jobs WHERE status = $1 ORDER BY created_at", status)
+}
+
+func (p *postgresHandler) GetJobsByKind(kind string) ([]*data.Job, error) {
+	return p.queryJobs("SELECT "+jobColumns+" FROM jobs WHERE kind = $1 ORDER BY created_at", kind)
+}
+
+func (p *postgresHandler) GetChildJobs(parentID string) ([]*data.Job, error) {
+	return p.queryJobs("SELECT "+jobColumns+" FROM jobs WHERE parent_id = $1 ORDER BY created_at, id", parentID)
+}
+
+func (p *postgresHandler) queryJobs(query string, args ...interface{}) ([]*data.Job, error) {
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying jobs: %v", err)
+	}
+	defer rows.Close()
+
+	jobs := []*data.Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning jobs: %v", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through rows: %v", err)
+	}
+
+	return jobs, nil
+}
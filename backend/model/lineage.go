@@ -0,0 +1,109 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+// RecordRecovery writes the inventory changes of a finished recovery and
+// its lineage links in one transaction, so the inventory never shows
+// volumes on both the failed VM and their new home.
+func (p *postgresHandler) RecordRecovery(vms []data.VMInstance, lineage []data.Lineage) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, vm := range vms {
+		err = upsertVM(tx, vm)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, link := range lineage {
+		volumesJSON, _ := json.Marshal(link.Volumes)
+		devicesJSON, _ := json.Marshal(link.Devices)
+		_, err = tx.Exec(`INSERT INTO lineage (source_id, replacement_id, job_id, kind, volumes, created_at, root_volume, devices)
+                          VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, NULLIF($7, ''), $8)`,
+			link.SourceID, link.ReplacementID, link.JobID, link.Kind, volumesJSON, link.CreatedAt, link.RootVolume, devicesJSON)
+		if err != nil {
+			return fmt.Errorf("error inserting lineage: %v", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing recovery: %v", err)
+	}
+	return nil
+}
+
+// GetLineage returns the links in which a VM is either the failed source
+// or a replacement, oldest first.
+func (p *postgresHandler) GetLineage(vmID string) ([]data.Lineage, error) {
+	rows, err := p.db.Query(`SELECT source_id, replacement_id, COALESCE(job_id, ''), kind, COALESCE(volumes, '[]'), created_at, failed_back_at,
+                                    COALESCE(root_volume, ''), COALESCE(devices, 'null')
+                             FROM lineage WHERE source_id = $1 OR replacement_id = $1 ORDER BY created_at`, vmID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying lineage: %v", err)
+	}
+	defer rows.Close()
+
+	lineage := []data.Lineage{}
+	for rows.Next() {
+		var link data.Lineage
+		var volumesStr, devicesStr string
+		var failedBackAt sql.NullTime
+		err := rows.Scan(&link.SourceID, &link.ReplacementID, &link.JobID, &link.Kind, &volumesStr, &link.CreatedAt, &failedBackAt, &link.RootVolume, &devicesStr)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning lineage: %v", err)
+		}
+		if failedBackAt.Valid {
+			link.FailedBackAt = &failedBackAt.Time
+		}
+		err = json.Unmarshal([]byte(volumesStr), &link.Volumes)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling lineage volumes: %v", err)
+		}
+		err = json.Unmarshal([]byte(devicesStr), &link.Devices)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling lineage devices: %v", err)
+		}
+		lineage = append(lineage, link)
+	}
+	return lineage, rows.Err()
+}
+
+// RecordFailback writes the inventory after a failback and closes the
+// source's open lineage links in one transaction.
+func (p *postgresHandler) RecordFailback(vms []data.VMInstance, sourceID string) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, vm := range vms {
+		err = upsertVM(tx, vm)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("UPDATE lineage SET failed_back_at = $2 WHERE source_id = $1 AND failed_back_at IS NULL", sourceID, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("error updating lineage: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing failback: %v", err)
+	}
+	return nil
+}
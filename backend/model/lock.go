@@ -0,0 +1,92 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// recoveryLockClass namespaces this service's advisory locks from any
+// other user of the same database.
+const recoveryLockClass = 4242
+
+// LockedError reports that a key is held by another in-flight job.
+type LockedError struct {
+	Key   string
+	JobID string
+}
+
+func (e *LockedError) Error() string {
+	if e.JobID == "" {
+		return fmt.Sprintf("%s is locked by another recovery", e.Key)
+	}
+	return fmt.Sprintf("%s is locked by job %s", e.Key, e.JobID)
+}
+
+// Lock is a set of held advisory locks.
+type Lock interface {
+	Release()
+}
+
+// advisoryLock keeps the session that holds the locks; Postgres releases
+// them on its own if the connection or the replica holding it dies.
+type advisoryLock struct {
+	db    *sql.DB
+	conn  *sql.Conn
+	jobID string
+	keys  []string
+}
+
+func (l *advisoryLock) Release() {
+	ctx := context.Background()
+	for _, key := range l.keys {
+		_, err := l.db.Exec("DELETE FROM recovery_locks WHERE key = $1 AND job_id = $2", key, l.jobID)
+		if err != nil {
+			fmt.Printf("Error clearing lock holder of %s: %s\n", key, err)
+		}
+		_, err = l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1, hashtext($2))", recoveryLockClass, key)
+		if err != nil {
+			fmt.Printf("Error releasing lock %s: %s\n", key, err)
+		}
+	}
+	l.conn.Close()
+}
+
+// AcquireLocks takes a session advisory lock on every key for jobID without
+// waiting. If any key is already held, the locks taken so far are released
+// and a LockedError naming the holding job is returned.
+func (p *postgresHandler) AcquireLocks(jobID string, keys []string) (Lock, error) {
+	ctx := context.Background()
+	conn, err := p.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lock connection: %v", err)
+	}
+
+	lock := &advisoryLock{db: p.db, conn: conn, jobID: jobID}
+	for _, key := range keys {
+		var acquired bool
+		err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1, hashtext($2))", recoveryLockClass, key).Scan(&acquired)
+		if err != nil {
+			lock.Release()
+			return nil, fmt.Errorf("error acquiring lock %s: %v", key, err)
+		}
+		if !acquired {
+			var holder string
+			p.db.QueryRow("SELECT job_id FROM recovery_locks WHERE key = $1", key).Scan(&holder)
+			lock.Release()
+			return nil, &LockedError{Key: key, JobID: holder}
+		}
+		lock.keys = append(lock.keys, key)
+
+		_, err = p.db.Exec(`INSERT INTO recovery_locks (key, job_id, acquired_at) VALUES ($1, $2, $3)
+                             ON CONFLICT (key) DO UPDATE SET job_id = EXCLUDED.job_id, acquired_at = EXCLUDED.acquired_at`,
+			key, jobID, time.Now().UTC())
+		if err != nil {
+			lock.Release()
+			return nil, fmt.Errorf("error recording lock holder of %s: %v", key, err)
+		}
+	}
+
+	return lock, nil
+}
@@ -1,6 +1,10 @@
 package model
 
-import "github.com/jaehanbyun/VM-Disaster-Recovery/data"
+import (
+	"time"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
 
 type DBHandler interface {
 	Close()
@@ -9,11 +13,48 @@ type DBHandler interface {
 	SetWeight(data.Weight) error
 	GetThreshold() (float32, error)
 	SetThreshold(float32) error
+	GetSetting(string) (string, error)
+	SetSetting(string, string) error
 	GetVMInfo(string) (*data.VMInstance, error)
 	GetVMsInfo() ([]*data.VMInstance, error)
 	SetVMInfo(data.VMInstance) error
 	SetVMsInfo() error
+	SetVMStrategy(string, string) error
+	SetVMStatus(string, string) error
+	SetVMTargetRTO(string, int) error
+	RecordRecovery([]data.VMInstance, []data.Lineage) error
+	GetLineage(string) ([]data.Lineage, error)
+	RecordFailback([]data.VMInstance, string) error
 	GetImageName(string) (string, error)
+	GetImageID(string) (string, error)
+	CreateJob(data.Job) error
+	UpdateJob(data.Job) error
+	UpdateJobIf(data.Job, string) error
+	GetJob(string) (*data.Job, error)
+	GetJobs(int) ([]*data.Job, error)
+	GetJobsByStatus(string) ([]*data.Job, error)
+	GetJobsByKind(string) ([]*data.Job, error)
+	GetChildJobs(string) ([]*data.Job, error)
+	ClaimJob(string, string) (*data.Job, error)
+	HeartbeatJobs(string) error
+	InterruptStaleJobs(time.Time, string) ([]string, error)
+	AcquireLocks(string, []string) (Lock, error)
+	ReserveIdempotencyKey(string, string, time.Time) (*data.IdempotencyRecord, error)
+	CompleteIdempotencyKey(string, string, int, []byte) error
+	ReleaseIdempotencyKey(string) error
+	GetPlanDefinitions() ([]*data.PlanDefinition, error)
+	GetPlanDefinition(string) (*data.PlanDefinition, error)
+	SetPlanDefinition(data.PlanDefinition) error
+	DeletePlanDefinition(string) error
+	GetValidation(string) (data.ValidationConfig, error)
+	SetValidation(string, data.ValidationConfig) error
+	GetHealth(string) (data.VMHealth, error)
+	GetHealths() ([]data.VMHealth, error)
+	SetHealth(data.VMHealth) error
+	DeleteHealth(string) error
+	GetPlacements() (map[string]int, error)
+	AddPlacement(string) error
+	RemovePlacement(string) error
 }
 
 func NewDBHandler() DBHandler {
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"fmt"
+)
+
+// GetPlacements returns how many recoveries were directed at each failure
+// domain.
+func (p *postgresHandler) GetPlacements() (map[string]int, error) {
+	rows, err := p.db.Query("SELECT domain, count FROM placements")
+	if err != nil {
+		return nil, fmt.Errorf("error querying placements: %v", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var domain string
+		var count int
+		err := rows.Scan(&domain, &count)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning placements: %v", err)
+		}
+		counts[domain] = count
+	}
+	return counts, rows.Err()
+}
+
+// AddPlacement counts one more recovery directed at domain.
+func (p *postgresHandler) AddPlacement(domain string) error {
+	_, err := p.db.Exec(`INSERT INTO placements (domain, count) VALUES ($1, 1)
+                         ON CONFLICT (domain) DO UPDATE SET count = placements.count + 1`, domain)
+	if err != nil {
+		return fmt.Errorf("error adding placement: %v", err)
+	}
+	return nil
+}
+
+// RemovePlacement takes back one recovery directed at domain, for a recovery
+// that was rolled back or failed back.
+func (p *postgresHandler) RemovePlacement(domain string) error {
+	_, err := p.db.Exec("UPDATE placements SET count = GREATEST(count - 1, 0) WHERE domain = $1", domain)
+	if err != nil {
+		return fmt.Errorf("error removing placement: %v", err)
+	}
+	return nil
+}
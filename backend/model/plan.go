@@ -0,0 +1,92 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+// ErrPlanNotFound is returned when no recovery plan has the requested name.
+var ErrPlanNotFound = errors.New("recovery plan not found")
+
+func scanPlanDefinition(row rowScanner) (*data.PlanDefinition, error) {
+	var definition string
+	var updatedAt time.Time
+	err := row.Scan(&definition, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan data.PlanDefinition
+	err = json.Unmarshal([]byte(definition), &plan)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling recovery plan: %v", err)
+	}
+	plan.UpdatedAt = &updatedAt
+	return &plan, nil
+}
+
+func (p *postgresHandler) GetPlanDefinitions() ([]*data.PlanDefinition, error) {
+	rows, err := p.db.Query("SELECT definition, updated_at FROM recovery_plans ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("error querying recovery plans: %v", err)
+	}
+	defer rows.Close()
+
+	plans := []*data.PlanDefinition{}
+	for rows.Next() {
+		plan, err := scanPlanDefinition(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning recovery plan: %v", err)
+		}
+		plans = append(plans, plan)
+	}
+	return plans, rows.Err()
+}
+
+func (p *postgresHandler) GetPlanDefinition(name string) (*data.PlanDefinition, error) {
+	row := p.db.QueryRow("SELECT definition, updated_at FROM recovery_plans WHERE name = $1", name)
+
+	plan, err := scanPlanDefinition(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrPlanNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error scanning recovery plan: %v", err)
+	}
+	return plan, nil
+}
+
+func (p *postgresHandler) SetPlanDefinition(plan data.PlanDefinition) error {
+	plan.UpdatedAt = nil
+	definition, err := json.Marshal(plan)
+	if err != nil {
+		return fmt.Errorf("error marshaling recovery plan: %v", err)
+	}
+
+	_, err = p.db.Exec(`INSERT INTO recovery_plans (name, definition, updated_at) VALUES ($1, $2, $3)
+                        ON CONFLICT (name) DO UPDATE SET definition = EXCLUDED.definition, updated_at = EXCLUDED.updated_at`,
+		plan.Name, definition, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("error storing recovery plan: %v", err)
+	}
+	return nil
+}
+
+func (p *postgresHandler) DeletePlanDefinition(name string) error {
+	result, err := p.db.Exec("DELETE FROM recovery_plans WHERE name = $1", name)
+	if err != nil {
+		return fmt.Errorf("error deleting recovery plan: %v", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error reading affected rows: %v", err)
+	}
+	if n == 0 {
+		return ErrPlanNotFound
+	}
+	return nil
+}
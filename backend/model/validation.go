@@ -0,0 +1,44 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jaehanbyun/VM-Disaster-Recovery/data"
+)
+
+// GetValidation returns the validation configured for a VM, which is empty
+// when none was set.
+func (p *postgresHandler) GetValidation(vmID string) (data.ValidationConfig, error) {
+	config := data.ValidationConfig{Probes: []data.Probe{}}
+
+	row := p.db.QueryRow("SELECT config FROM validations WHERE vm_id = $1", vmID)
+	var configStr string
+	err := row.Scan(&configStr)
+	if err == sql.ErrNoRows {
+		return config, nil
+	} else if err != nil {
+		return config, fmt.Errorf("error scanning validation: %v", err)
+	}
+
+	err = json.Unmarshal([]byte(configStr), &config)
+	if err != nil {
+		return config, fmt.Errorf("error unmarshaling validation: %v", err)
+	}
+	return config, nil
+}
+
+func (p *postgresHandler) SetValidation(vmID string, config data.ValidationConfig) error {
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("error marshaling validation: %v", err)
+	}
+
+	_, err = p.db.Exec(`INSERT INTO validations (vm_id, config) VALUES ($1, $2)
+                        ON CONFLICT (vm_id) DO UPDATE SET config = EXCLUDED.config`, vmID, configJSON)
+	if err != nil {
+		return fmt.Errorf("error storing validation: %v", err)
+	}
+	return nil
+}